@@ -0,0 +1,237 @@
+package main
+
+func evalToArray(node Node) (*ArrayNode, error) {
+
+	a, ok := node.(*ArrayNode)
+	if !ok {
+		return nil, errorArrayExpected(node)
+	}
+	return a, nil
+}
+
+func evalToInteger(node Node) (int, error) {
+
+	n, err := evalToNumber(node)
+	if err != nil {
+		return 0, err
+	}
+	if n != float64(int(n)) {
+		return 0, errorBadInput(node)
+	}
+	return int(n), nil
+}
+
+func arrayOriginParam(parameters []Node, ix int) (int, error) {
+
+	if len(parameters) <= ix {
+		return 1, nil
+	}
+	return evalToInteger(parameters[ix])
+}
+
+func (this *ArrayNode) slot(index Node) (int, error) {
+
+	ix, err := evalToInteger(index)
+	if err != nil {
+		return 0, err
+	}
+	ix -= this.array.origin
+	if ix < 0 || ix >= len(this.array.items) {
+		return 0, errorBadInput(index)
+	}
+	return ix, nil
+}
+
+func (this *ArrayNode) item(index Node) (Node, error) {
+
+	ix, err := this.slot(index)
+	if err != nil {
+		return nil, err
+	}
+	return copyNode(this.array.items[ix]), nil
+}
+
+func (this *ArrayNode) setItem(index, value Node) error {
+
+	ix, err := this.slot(index)
+	if err != nil {
+		return err
+	}
+	va, isArray := value.(*ArrayNode)
+	if isArray && va.array == this.array {
+		return errorBadInput(value)
+	}
+	this.array.items[ix] = copyNode(value)
+	return nil
+}
+
+func makeArray(sizes []int, origin int) *ArrayNode {
+
+	items := make([]Node, sizes[0])
+	for ix := range items {
+		if len(sizes) > 1 {
+			items[ix] = makeArray(sizes[1:], origin)
+		} else {
+			items[ix] = newListNode(-1, -1, nil)
+		}
+	}
+	return newArrayNode(-1, -1, items, origin)
+}
+
+// mdArray follows a list of indices down through nested arrays, returning
+// the array that holds the last index.
+func mdArray(array, indices Node) (*ArrayNode, Node, error) {
+
+	il, ok := indices.(*ListNode)
+	if !ok || il.firstChild == nil {
+		return nil, nil, errorListExpected(indices)
+	}
+
+	a, err := evalToArray(array)
+	if err != nil {
+		return nil, nil, err
+	}
+	items := il.index()
+	for _, ix := range items[:len(items)-1] {
+		n, err := a.item(ix)
+		if err != nil {
+			return nil, nil, err
+		}
+		a, err = evalToArray(n)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return a, items[len(items)-1], nil
+}
+
+func _bi_Array(frame Frame, parameters []Node) *CallResult {
+
+	size, err := evalToInteger(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+	if size < 0 {
+		return errorResult(errorBadInput(parameters[0]))
+	}
+	err = checkValueSize(frame, int64(size))
+	if err != nil {
+		return errorResult(err)
+	}
+	origin, err := arrayOriginParam(parameters, 1)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	return returnResult(makeArray([]int{size}, origin))
+}
+
+func _bi_MdArray(frame Frame, parameters []Node) *CallResult {
+
+	sl, ok := parameters[0].(*ListNode)
+	if !ok || sl.firstChild == nil {
+		return errorResult(errorListExpected(parameters[0]))
+	}
+	sizes := make([]int, 0, sl.length())
+	total := int64(1)
+	for _, n := range sl.index() {
+		size, err := evalToInteger(n)
+		if err != nil {
+			return errorResult(err)
+		}
+		if size < 0 {
+			return errorResult(errorBadInput(n))
+		}
+		total *= int64(size)
+		err = checkValueSize(frame, total)
+		if err != nil {
+			return errorResult(err)
+		}
+		sizes = append(sizes, size)
+	}
+	origin, err := arrayOriginParam(parameters, 1)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	return returnResult(makeArray(sizes, origin))
+}
+
+func _bi_ListToArray(frame Frame, parameters []Node) *CallResult {
+
+	l, ok := parameters[0].(*ListNode)
+	if !ok {
+		return errorResult(errorListExpected(parameters[0]))
+	}
+	origin, err := arrayOriginParam(parameters, 1)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	items := make([]Node, 0, l.length())
+	for _, n := range l.index() {
+		items = append(items, copyNode(n))
+	}
+	return returnResult(newArrayNode(-1, -1, items, origin))
+}
+
+func _bi_ArrayToList(frame Frame, parameters []Node) *CallResult {
+
+	a, err := evalToArray(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	return returnResult(nodesToList(a.array.items))
+}
+
+func _bi_Arrayp(frame Frame, parameters []Node) *CallResult {
+
+	switch parameters[0].(type) {
+	case *ArrayNode:
+		return returnResult(trueNode)
+	}
+	return returnResult(falseNode)
+}
+
+func _bi_SetItem(frame Frame, parameters []Node) *CallResult {
+
+	a, err := evalToArray(parameters[1])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	err = a.setItem(parameters[0], parameters[2])
+	if err != nil {
+		return errorResult(err)
+	}
+	return nil
+}
+
+func _bi_MdItem(frame Frame, parameters []Node) *CallResult {
+
+	a, ix, err := mdArray(parameters[1], parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	n, err := a.item(ix)
+	if err != nil {
+		return errorResult(err)
+	}
+	return returnResult(n)
+}
+
+func _bi_MdSetItem(frame Frame, parameters []Node) *CallResult {
+
+	a, ix, err := mdArray(parameters[1], parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	err = a.setItem(ix, parameters[2])
+	if err != nil {
+		return errorResult(err)
+	}
+	return nil
+}
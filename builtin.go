@@ -12,6 +12,7 @@ import (
 )
 
 var keywordTo string = "TO"
+var keywordMacro string = ".MACRO"
 var keywordEnd string = "END"
 var keywordTrue string = "TRUE"
 var keywordFalse string = "FALSE"
@@ -21,13 +22,13 @@ var keywordGo string = "GO"
 var keywordLabel string = "LABEL"
 var keywordError string = "ERROR"
 var keywordEdit string = "EDIT"
+var keywordOutput string = "OUTPUT"
+var keywordContinue string = "CONTINUE"
 
 var trueNode Node = newWordNode(-1, -1, keywordTrue, true)
 var falseNode Node = newWordNode(-1, -1, keywordFalse, true)
 var randomMax Node = newWordNode(-1, -1, "10", true)
 
-var traceEnabled bool
-
 func _bi_Go(frame Frame, parameters []Node) *CallResult {
 	// Dummy - call handled in eval.go
 	return nil
@@ -64,24 +65,234 @@ func _bi_Stop(frame Frame, parameters []Node) *CallResult {
 	return stopResult()
 }
 
+func evalLoopBody(frame Frame, body Node) *CallResult {
+
+	cr := checkSandbox(frame)
+	if cr != nil {
+		return cr
+	}
+	cr = evalInstructionList(frame, body, false)
+	if cr != nil && cr.shouldStop() {
+		return cr
+	}
+	if isFrameStopped(frame) {
+		return stopResult()
+	}
+	if frame.workspace().interrupted {
+		return errorResult(errorUserStopped(frame.caller()))
+	}
+	return nil
+}
+
+func evalLoopCondition(frame Frame, cond Node) (bool, *CallResult) {
+
+	cr := checkSandbox(frame)
+	if cr != nil {
+		return false, cr
+	}
+	cr = evalInstructionList(frame, cond, true)
+	if cr != nil && cr.shouldStop() {
+		return false, cr
+	}
+	if cr == nil || cr.returnValue == nil {
+		return false, errorResult(errorBooleanExpected(cond))
+	}
+	b, err := evalToBoolean(cr.returnValue)
+	if err != nil {
+		return false, errorResult(err)
+	}
+	return b, nil
+}
+
 func _bi_Repeat(frame Frame, parameters []Node) *CallResult {
 
 	n, err := evalToNumber(parameters[0])
 	if err != nil {
 		return errorResult(err)
 	}
-	nn := int64(n)
+	nn := int(n)
+
+	bf := frame.(*BuiltInFrame)
+	for bf.repCount = 1; bf.repCount <= nn; bf.repCount++ {
+		cr := evalLoopBody(frame, parameters[1])
+		if cr != nil {
+			return cr
+		}
+	}
+
+	return nil
+}
+
+func _bi_SetRecursionLimit(frame Frame, parameters []Node) *CallResult {
+
+	n, err := evalToNumber(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+	if n < 1 || n != math.Floor(n) {
+		return errorResult(errorPositiveIntegerExpected(parameters[0]))
+	}
+
+	frame.workspace().maxDepth = int(n)
+	return nil
+}
+
+func _bi_RecursionLimit(frame Frame, parameters []Node) *CallResult {
 
-	for ix := int64(0); ix < nn; ix++ {
-		cr := evalInstructionList(frame, parameters[1], false)
+	return returnResult(createNumericNode(float64(frame.workspace().maxDepth)))
+}
+
+func _bi_Forever(frame Frame, parameters []Node) *CallResult {
+
+	bf := frame.(*BuiltInFrame)
+	for bf.repCount = 1; ; bf.repCount++ {
+		cr := evalLoopBody(frame, parameters[0])
+		if cr != nil {
+			return cr
+		}
+	}
+}
+
+func _bi_RepCount(frame Frame, parameters []Node) *CallResult {
+
+	for f := frame.parentFrame(); f != nil; f = f.parentFrame() {
+		bf, ok := f.(*BuiltInFrame)
+		if ok && bf.repCount > 0 {
+			return returnResult(createNumericNode(float64(bf.repCount)))
+		}
+	}
+	return returnResult(createNumericNode(-1))
+}
+
+func _bi_For(frame Frame, parameters []Node) *CallResult {
+
+	ctrl, ok := parameters[0].(*ListNode)
+	if !ok || ctrl.firstChild == nil {
+		return errorResult(errorListExpected(parameters[0]))
+	}
+	ctrl = ctrl.clone().(*ListNode)
+
+	name, err := evalToWord(ctrl.firstChild)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	values := make([]float64, 0, 3)
+	for n := ctrl.firstChild.next(); n != nil; {
+		var cr *CallResult
+		cr, n = evaluateExpression(frame, n)
 		if cr != nil && cr.shouldStop() {
 			return cr
 		}
+		if cr == nil || cr.returnValue == nil {
+			return errorResult(errorNumberExpected(parameters[0]))
+		}
+		v, err := evalToNumber(cr.returnValue)
+		if err != nil {
+			return errorResult(err)
+		}
+		values = append(values, v)
+	}
+	if len(values) < 2 || len(values) > 3 {
+		return errorResult(errorBadInput(parameters[0]))
+	}
+
+	start, end := values[0], values[1]
+	step := 1.0
+	if end < start {
+		step = -1.0
+	}
+	if len(values) == 3 {
+		step = values[2]
+	}
+	if step == 0 {
+		return errorResult(errorBadInput(parameters[0]))
+	}
+
+	frame.getVars().createLocal(name)
+	for ix := 0; ; ix++ {
+		v := start + float64(ix)*step
+		if (step > 0 && v > end) || (step < 0 && v < end) {
+			break
+		}
+		frame.getVars().setVariable(frame, name, createNumericNode(v))
+		cr := evalLoopBody(frame, parameters[1])
+		if cr != nil {
+			return cr
+		}
 	}
 
 	return nil
 }
 
+func _bi_While(frame Frame, parameters []Node) *CallResult {
+
+	for {
+		b, cr := evalLoopCondition(frame, parameters[0])
+		if cr != nil {
+			return cr
+		}
+		if !b {
+			return nil
+		}
+		cr = evalLoopBody(frame, parameters[1])
+		if cr != nil {
+			return cr
+		}
+	}
+}
+
+func _bi_Until(frame Frame, parameters []Node) *CallResult {
+
+	for {
+		b, cr := evalLoopCondition(frame, parameters[0])
+		if cr != nil {
+			return cr
+		}
+		if b {
+			return nil
+		}
+		cr = evalLoopBody(frame, parameters[1])
+		if cr != nil {
+			return cr
+		}
+	}
+}
+
+func _bi_DoWhile(frame Frame, parameters []Node) *CallResult {
+
+	for {
+		cr := evalLoopBody(frame, parameters[0])
+		if cr != nil {
+			return cr
+		}
+		b, cr := evalLoopCondition(frame, parameters[1])
+		if cr != nil {
+			return cr
+		}
+		if !b {
+			return nil
+		}
+	}
+}
+
+func _bi_DoUntil(frame Frame, parameters []Node) *CallResult {
+
+	for {
+		cr := evalLoopBody(frame, parameters[0])
+		if cr != nil {
+			return cr
+		}
+		b, cr := evalLoopCondition(frame, parameters[1])
+		if cr != nil {
+			return cr
+		}
+		if b {
+			return nil
+		}
+	}
+}
+
 func _bi_Print(frame Frame, parameters []Node) *CallResult {
 
 	for _, p := range parameters {
@@ -186,8 +397,8 @@ func _bi_ReadChars(frame Frame, parameters []Node) *CallResult {
 
 func _bi_Request(frame Frame, parameters []Node) *CallResult {
 
-	fw := frame.workspace().files.reader
-	fr := frame.workspace().files.writer
+	fw := frame.workspace().files.writer
+	fr := frame.workspace().files.reader
 	fw.Write(promptPrimary)
 	line, err := fr.ReadLine()
 	if err != nil {
@@ -203,98 +414,99 @@ func _bi_Request(frame Frame, parameters []Node) *CallResult {
 
 func _bi_Sum(frame Frame, parameters []Node) *CallResult {
 
-	n1, err := evalToNumber(parameters[0])
+	n1, err := evalToNumeric(parameters[0])
 	if err != nil {
 		return errorResult(err)
 	}
 
 	for ix := 1; ix < len(parameters); ix++ {
-		n2, err := evalToNumber(parameters[ix])
+		n2, err := evalToNumeric(parameters[ix])
 		if err != nil {
 			return errorResult(err)
 		}
-		n1 += n2
+		n1 = addNumeric(n1, n2)
 	}
 
-	return returnResult(createNumericNode(n1))
+	return returnResult(createNumberNode(n1))
 }
 
 func _bi_Difference(frame Frame, parameters []Node) *CallResult {
 
-	var x, y float64
-	var err error
-	x, y, err = evalNumericParams(parameters[0], parameters[1])
+	x, y, err := evalNumericPair(parameters[0], parameters[1])
 	if err != nil {
 		return errorResult(err)
 	}
 
-	return returnResult(createNumericNode(x - y))
+	return returnResult(createNumberNode(subtractNumeric(x, y)))
 }
 
 func _bi_Product(frame Frame, parameters []Node) *CallResult {
 
-	n1, err := evalToNumber(parameters[0])
+	n1, err := evalToNumeric(parameters[0])
 	if err != nil {
 		return errorResult(err)
 	}
 
 	for ix := 1; ix < len(parameters); ix++ {
-		n2, err := evalToNumber(parameters[ix])
+		n2, err := evalToNumeric(parameters[ix])
 		if err != nil {
 			return errorResult(err)
 		}
-		n1 *= n2
+		n1 = multiplyNumeric(n1, n2)
 	}
 
-	return returnResult(createNumericNode(n1))
+	return returnResult(createNumberNode(n1))
 }
 
 func _bi_Quotient(frame Frame, parameters []Node) *CallResult {
 
-	x, y, err := evalNumericParams(parameters[0], parameters[1])
+	x, y, err := evalNumericPair(parameters[0], parameters[1])
 	if err != nil {
 		return errorResult(err)
 	}
 
-	if y == 0 {
+	if isZeroNumeric(y) {
 		return errorResult(errorAttemptToDivideByZero(parameters[1]))
 	}
-	return returnResult(createNumericNode(x / y))
+	return returnResult(createNumberNode(divideNumeric(x, y)))
 }
 
 func _bi_IntQuotient(frame Frame, parameters []Node) *CallResult {
 
-	x, y, err := evalNumericParams(parameters[0], parameters[1])
+	x, y, err := evalNumericPair(parameters[0], parameters[1])
 	if err != nil {
 		return errorResult(err)
 	}
 
-	if y == 0 {
+	if isZeroNumeric(truncateNumeric(y)) {
 		return errorResult(errorAttemptToDivideByZero(parameters[1]))
 	}
-	return returnResult(createNumericNode(float64(int64(x) / int64(y))))
+	return returnResult(createNumberNode(intQuotientNumeric(x, y)))
 }
 
 func _bi_Int(frame Frame, parameters []Node) *CallResult {
 
-	n, err := evalToNumber(parameters[0])
+	n, err := evalToNumeric(parameters[0])
 	if err != nil {
 		return errorResult(err)
 	}
 
-	return returnResult(createNumericNode(float64(int64(n))))
+	return returnResult(createNumberNode(truncateNumeric(n)))
 }
 
 func _bi_Round(frame Frame, parameters []Node) *CallResult {
 
-	n, prec, err := evalNumericParams(parameters[0], parameters[1])
+	n, prec, err := evalNumericPair(parameters[0], parameters[1])
 	if err != nil {
 		return errorResult(err)
 	}
+	if n.isExact() && compareNumeric(prec, integerNumeric(0)) >= 0 {
+		return returnResult(createNumberNode(n))
+	}
 
 	var r float64
-	pow := math.Pow(10, float64(prec))
-	i := n * pow
+	pow := math.Pow(10, prec.float())
+	i := n.float() * pow
 	_, frac := math.Modf(i)
 	if frac >= 0.5 {
 		r = math.Ceil(i)
@@ -328,36 +540,48 @@ func _bi_Form(frame Frame, parameters []Node) *CallResult {
 
 func _bi_Remainder(frame Frame, parameters []Node) *CallResult {
 
-	x, y, err := evalNumericParams(parameters[0], parameters[1])
+	x, y, err := evalNumericPair(parameters[0], parameters[1])
 	if err != nil {
 		return errorResult(err)
 	}
 
-	if y == 0 {
+	if isZeroNumeric(truncateNumeric(y)) {
 		return errorResult(errorAttemptToDivideByZero(parameters[1]))
 	}
 
-	return returnResult(createNumericNode(float64(int64(x) % int64(y))))
+	return returnResult(createNumberNode(remainderNumeric(x, y)))
 }
 
-func _bi_Maximum(frame Frame, parameters []Node) *CallResult {
+// extremeNumeric returns the input that compares as sign against all the
+// others.
+func extremeNumeric(parameters []Node, sign int) *CallResult {
 
-	x, y, err := evalNumericParams(parameters[0], parameters[1])
+	r, err := evalToNumeric(parameters[0])
 	if err != nil {
 		return errorResult(err)
 	}
 
-	return returnResult(createNumericNode(math.Max(x, y)))
+	for _, p := range parameters[1:] {
+		n, err := evalToNumeric(p)
+		if err != nil {
+			return errorResult(err)
+		}
+		if compareNumeric(n, r) == sign {
+			r = n
+		}
+	}
+
+	return returnResult(createNumberNode(r))
 }
 
-func _bi_Minimum(frame Frame, parameters []Node) *CallResult {
+func _bi_Maximum(frame Frame, parameters []Node) *CallResult {
 
-	x, y, err := evalNumericParams(parameters[0], parameters[1])
-	if err != nil {
-		return errorResult(err)
-	}
+	return extremeNumeric(parameters, 1)
+}
+
+func _bi_Minimum(frame Frame, parameters []Node) *CallResult {
 
-	return returnResult(createNumericNode(math.Min(x, y)))
+	return extremeNumeric(parameters, -1)
 }
 
 func _bi_Equalp(frame Frame, parameters []Node) *CallResult {
@@ -393,53 +617,42 @@ func _bi_NotEqualp(frame Frame, parameters []Node) *CallResult {
 	return returnResult(trueNode)
 }
 
-func _bi_Greaterp(frame Frame, parameters []Node) *CallResult {
+// compareParams compares two numeric inputs, reporting false when either is
+// not a number.
+func compareParams(parameters []Node, test func(c int) bool) *CallResult {
 
-	nx, ex := evalToNumber(parameters[0])
-	ny, ey := evalToNumber(parameters[1])
+	nx, ex := evalToNumeric(parameters[0])
+	ny, ey := evalToNumeric(parameters[1])
 
-	if ex == nil && ey == nil && nx > ny {
+	if ex == nil && ey == nil && test(compareNumeric(nx, ny)) {
 		return returnResult(trueNode)
 	}
 	return returnResult(falseNode)
 }
 
-func _bi_Lessp(frame Frame, parameters []Node) *CallResult {
+func _bi_Greaterp(frame Frame, parameters []Node) *CallResult {
 
-	nx, ex := evalToNumber(parameters[0])
-	ny, ey := evalToNumber(parameters[1])
+	return compareParams(parameters, func(c int) bool { return c > 0 })
+}
 
-	if ex == nil && ey == nil && nx < ny {
-		return returnResult(trueNode)
-	}
-	return returnResult(falseNode)
+func _bi_Lessp(frame Frame, parameters []Node) *CallResult {
+
+	return compareParams(parameters, func(c int) bool { return c < 0 })
 }
 
 func _bi_GreaterEqualp(frame Frame, parameters []Node) *CallResult {
 
-	nx, ex := evalToNumber(parameters[0])
-	ny, ey := evalToNumber(parameters[1])
-
-	if ex == nil && ey == nil && nx >= ny {
-		return returnResult(trueNode)
-	}
-	return returnResult(falseNode)
+	return compareParams(parameters, func(c int) bool { return c >= 0 })
 }
 
 func _bi_LessEqualp(frame Frame, parameters []Node) *CallResult {
 
-	nx, ex := evalToNumber(parameters[0])
-	ny, ey := evalToNumber(parameters[1])
-
-	if ex == nil && ey == nil && nx <= ny {
-		return returnResult(trueNode)
-	}
-	return returnResult(falseNode)
+	return compareParams(parameters, func(c int) bool { return c <= 0 })
 }
 
 func _bi_Numberp(frame Frame, parameters []Node) *CallResult {
 
-	_, err := evalToNumber(parameters[0])
+	_, err := evalToNumeric(parameters[0])
 	if err != nil {
 		return returnResult(falseNode)
 	}
@@ -448,8 +661,8 @@ func _bi_Numberp(frame Frame, parameters []Node) *CallResult {
 
 func _bi_Zerop(frame Frame, parameters []Node) *CallResult {
 
-	n, err := evalToNumber(parameters[0])
-	if err != nil || n != 0.0 {
+	n, err := evalToNumeric(parameters[0])
+	if err != nil || !isZeroNumeric(n) {
 		return returnResult(falseNode)
 	}
 	return returnResult(trueNode)
@@ -489,16 +702,16 @@ func _bi_Sqrt(frame Frame, parameters []Node) *CallResult {
 }
 
 func _bi_Pow(frame Frame, parameters []Node) *CallResult {
-	nx, err := evalToNumber(parameters[0])
+	nx, err := evalToNumeric(parameters[0])
 	if err != nil {
 		return errorResult(err)
 	}
-	ny, err := evalToNumber(parameters[1])
+	ny, err := evalToNumeric(parameters[1])
 	if err != nil {
 		return errorResult(err)
 	}
 
-	return returnResult(createNumericNode(math.Pow(nx, ny)))
+	return returnResult(createNumberNode(powerNumeric(nx, ny)))
 }
 
 func _bi_Sin(frame Frame, parameters []Node) *CallResult {
@@ -776,45 +989,35 @@ func _bi_Word(frame Frame, parameters []Node) *CallResult {
 
 func _bi_Sentence(frame Frame, parameters []Node) *CallResult {
 
-	var fn Node
-	var ln Node
-	var c Node
+	items := make([]Node, 0, len(parameters))
 	for _, n := range parameters {
 
 		switch nn := n.(type) {
 		case *WordNode:
-			c = nn.clone()
+			items = append(items, nn)
 		case *ListNode:
-			c = (nn.clone()).(*ListNode).firstChild
+			items = append(items, nn.index()...)
 		}
-		if fn == nil {
-			fn = c
-		} else {
-			ln.addNode(c)
-		}
-		ln = c
 	}
 
-	return returnResult(newListNode(-1, -1, fn))
+	return returnResult(nodesToList(items))
 }
 
 func _bi_List(frame Frame, parameters []Node) *CallResult {
 
-	l := parameters[0].clone()
-	l.addNode(parameters[1].clone())
-
-	return returnResult(newListNode(-1, -1, l))
+	return returnResult(nodesToList(parameters))
 }
 
 func _bi_FPut(frame Frame, parameters []Node) *CallResult {
 
-	l := parameters[0].clone()
-
 	switch r := parameters[1].(type) {
 	case *ListNode:
-		rc := r.clone().(*ListNode)
-		l.addNode(rc.firstChild)
-		rc.firstChild = l
+		l := copyNode(parameters[0])
+		l.addNode(r.firstChild)
+		rc := newListNode(r.line, r.col, l)
+		if r.count >= 0 {
+			rc.count = r.count + 1
+		}
 		return returnResult(rc)
 	}
 	return errorResult(errorListExpected(parameters[1]))
@@ -822,21 +1025,10 @@ func _bi_FPut(frame Frame, parameters []Node) *CallResult {
 
 func _bi_LPut(frame Frame, parameters []Node) *CallResult {
 
-	l := parameters[0].clone()
-
 	switch r := parameters[1].(type) {
 	case *ListNode:
-		rc := r.clone().(*ListNode)
-		n := rc.firstChild
-		for n != nil && n.next() != nil {
-			n = n.next()
-		}
-		if n == nil {
-			rc.firstChild = l
-		} else {
-			n.addNode(l)
-		}
-		return returnResult(rc)
+		items := r.index()
+		return returnResult(nodesToList(append(items[:len(items):len(items)], parameters[0])))
 	}
 	return errorResult(errorListExpected(parameters[1]))
 }
@@ -875,8 +1067,9 @@ func _bi_First(frame Frame, parameters []Node) *CallResult {
 		if n.firstChild == nil {
 			return errorResult(errorBadInput(n))
 		}
-		return returnResult(n.firstChild.clone())
-
+		return returnResult(copyNode(n.firstChild))
+	case *ArrayNode:
+		return returnResult(createNumericNode(float64(n.array.origin)))
 	}
 
 	return nil
@@ -894,11 +1087,10 @@ func _bi_Last(frame Frame, parameters []Node) *CallResult {
 		if n.firstChild == nil {
 			return errorResult(errorBadInput(n))
 		}
-		nn := n.firstChild
-		for nn.next() != nil {
-			nn = nn.next()
-		}
-		return returnResult(nn.clone())
+		items := n.index()
+		return returnResult(copyNode(items[len(items)-1]))
+	case *ArrayNode:
+		return errorResult(errorBadInput(n))
 	}
 
 	return nil
@@ -916,9 +1108,16 @@ func _bi_ButFirst(frame Frame, parameters []Node) *CallResult {
 		if n.firstChild == nil {
 			return errorResult(errorBadInput(n))
 		}
-		nn := n.clone().(*ListNode)
-		nn.firstChild = nn.firstChild.next()
+		nn := newListNode(n.line, n.col, n.firstChild.next())
+		if n.items != nil {
+			nn.items = n.items[1:]
+			nn.count = len(nn.items)
+		} else if n.count > 0 {
+			nn.count = n.count - 1
+		}
 		return returnResult(nn)
+	case *ArrayNode:
+		return errorResult(errorBadInput(n))
 	}
 
 	return nil
@@ -936,15 +1135,10 @@ func _bi_ButLast(frame Frame, parameters []Node) *CallResult {
 		if n.firstChild == nil {
 			return errorResult(errorBadInput(n))
 		}
-		nn := n.clone().(*ListNode)
-		var pn Node = nil
-		for cn := nn.firstChild; cn.next() != nil; cn = cn.next() {
-			pn = cn
-		}
-		if pn != nil {
-			pn.addNode(nil)
-		}
-		return returnResult(nn)
+		items := n.index()
+		return returnResult(nodesToList(items[:len(items)-1]))
+	case *ArrayNode:
+		return errorResult(errorBadInput(n))
 	}
 
 	return nil
@@ -957,6 +1151,8 @@ func _bi_Count(frame Frame, parameters []Node) *CallResult {
 		return returnResult(createNumericNode(float64(len(n.value))))
 	case *ListNode:
 		return returnResult(createNumericNode(float64(n.length())))
+	case *ArrayNode:
+		return returnResult(createNumericNode(float64(len(n.array.items))))
 	}
 
 	return nil
@@ -1038,6 +1234,15 @@ func _bi_Memberp(frame Frame, parameters []Node) *CallResult {
 
 func _bi_Item(frame Frame, parameters []Node) *CallResult {
 
+	a, isArray := parameters[1].(*ArrayNode)
+	if isArray {
+		n, err := a.item(parameters[0])
+		if err != nil {
+			return errorResult(err)
+		}
+		return returnResult(n)
+	}
+
 	ix := int64(0)
 	switch n := parameters[0].(type) {
 	case *WordNode:
@@ -1063,14 +1268,11 @@ func _bi_Item(frame Frame, parameters []Node) *CallResult {
 		return returnResult(newWordNode(-1, -1, string(v.value[ix-1]), true))
 
 	case *ListNode:
-		cn := v.firstChild
-		for i := int64(1); i < ix; i++ {
-			cn = cn.next()
-		}
-		if cn == nil {
+		items := v.index()
+		if ix > int64(len(items)) {
 			return errorResult(errorBadInput(parameters[0]))
 		}
-		return returnResult(cn.clone())
+		return returnResult(copyNode(items[ix-1]))
 	}
 
 	return nil
@@ -1127,20 +1329,6 @@ func _bi_Not(frame Frame, parameters []Node) *CallResult {
 	return returnResult(trueNode)
 }
 
-func _bi_Trace(frame Frame, parameters []Node) *CallResult {
-
-	frame.workspace().setTrace(true)
-
-	return nil
-}
-
-func _bi_Untrace(frame Frame, parameters []Node) *CallResult {
-
-	frame.workspace().setTrace(false)
-
-	return nil
-}
-
 func _bi_Step(frame Frame, parameters []Node) *CallResult {
 
 	names, err := toWordList(parameters[0])
@@ -1156,6 +1344,7 @@ func _bi_Step(frame Frame, parameters []Node) *CallResult {
 		switch ip := p.(type) {
 		case *InterpretedProcedure:
 			ip.step = true
+			ws.invalidateCode()
 		default:
 			return errorResult(errorProcIsBuiltIn(n, n.value))
 		}
@@ -1179,6 +1368,7 @@ func _bi_Unstep(frame Frame, parameters []Node) *CallResult {
 		switch ip := p.(type) {
 		case *InterpretedProcedure:
 			ip.step = false
+			ws.invalidateCode()
 		default:
 			return errorResult(errorProcIsBuiltIn(n, n.value))
 		}
@@ -1199,6 +1389,14 @@ func _bi_Wait(frame Frame, parameters []Node) *CallResult {
 		return errorResult(errorBadInput(parameters[0]))
 	}
 
+	sb := frame.workspace().sandbox
+	if sb != nil {
+		err = sb.wait(s*time.Second, frame.caller())
+		if err != nil {
+			return errorResult(err)
+		}
+		return nil
+	}
 	time.Sleep(s * time.Second)
 
 	return nil
@@ -1259,13 +1457,7 @@ func printVariable(ws *Workspace, n string, v Node) {
 
 func printTitle(ws *Workspace, p *InterpretedProcedure) {
 
-	ws.print("TO ")
-	ws.print(p.name)
-
-	for _, i := range p.parameters {
-		ws.print(" :")
-		ws.print(i)
-	}
+	ws.print(p.title())
 	ws.print("\n")
 }
 
@@ -1400,7 +1592,7 @@ func _bi_Erase(frame Frame, parameters []Node) *CallResult {
 		switch ip := p.(type) {
 		case *InterpretedProcedure:
 			if !ip.buried {
-				delete(ws.procedures, name)
+				ws.removeProcedure(name)
 			}
 		}
 	}
@@ -1447,7 +1639,7 @@ func _bi_Erps(frame Frame, parameters []Node) *CallResult {
 		switch ip := p.(type) {
 		case *InterpretedProcedure:
 			if !ip.buried {
-				delete(ws.procedures, n)
+				ws.removeProcedure(n)
 			}
 		}
 	}
@@ -1571,14 +1763,14 @@ func _bi_Load(frame Frame, parameters []Node) *CallResult {
 	if err != nil {
 		return errorResult(err)
 	}
+	defer ws.files.CloseFile(name)
 
-	err = ws.files.SetReader(name)
+	f, err := ws.files.GetFile(name)
 	if err != nil {
 		return errorResult(err)
 	}
-	defer ws.files.CloseFile(name)
 
-	err = ws.readFile()
+	err = ws.readFile(f, false)
 	if err != nil && err != io.EOF {
 		return errorResult(err)
 	}
@@ -1594,16 +1786,19 @@ func _bi_Save(frame Frame, parameters []Node) *CallResult {
 	}
 
 	ws := frame.workspace()
-	err = ws.files.OpenFile(name)
+	err = ws.files.CreateFile(name)
 	if err != nil {
 		return errorResult(err)
 	}
+	defer ws.files.CloseFile(name)
+
+	fw := ws.files.writer
+	defer func() { ws.files.writer = fw }()
 
 	err = ws.files.SetWriter(name)
 	if err != nil {
 		return errorResult(err)
 	}
-	defer ws.files.CloseFile(name)
 
 	return _bi_PoAll(frame, parameters)
 }
@@ -1621,16 +1816,19 @@ func _bi_Savel(frame Frame, parameters []Node) *CallResult {
 	}
 
 	ws := frame.workspace()
-	err = ws.files.OpenFile(name)
+	err = ws.files.CreateFile(name)
 	if err != nil {
 		return errorResult(err)
 	}
+	defer ws.files.CloseFile(name)
+
+	fw := ws.files.writer
+	defer func() { ws.files.writer = fw }()
 
 	err = ws.files.SetWriter(name)
 	if err != nil {
 		return errorResult(err)
 	}
-	defer ws.files.CloseFile(name)
 
 	for _, n := range names {
 		p := ws.findProcedure(strings.ToUpper(n.value))
@@ -1743,13 +1941,13 @@ func _bi_Pofile(frame Frame, parameters []Node) *CallResult {
 	}
 	defer fs.CloseFile(p)
 
-	err = fs.SetReader(p)
+	f, err := fs.GetFile(p)
 	if err != nil {
 		return errorResult(err)
 	}
 
 	for {
-		l, err := fs.reader.ReadLine()
+		l, err := f.ReadLine()
 
 		if err != nil {
 			if err == io.EOF {
@@ -1765,6 +1963,216 @@ func _bi_Pofile(frame Frame, parameters []Node) *CallResult {
 	return nil
 }
 
+func evalToStreamName(node Node) (string, error) {
+
+	switch n := node.(type) {
+	case *ListNode:
+		if n.firstChild == nil {
+			return "", nil
+		}
+		return "", errorWordExpected(node)
+	}
+	return evalToWord(node)
+}
+
+func streamNameNode(f File) Node {
+
+	if f.Name() == "" {
+		return newListNode(-1, -1, nil)
+	}
+	return newWordNode(-1, -1, f.Name(), true)
+}
+
+func _bi_Open(frame Frame, parameters []Node) *CallResult {
+
+	name, err := evalToWord(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	err = frame.workspace().files.OpenFile(name)
+	if err != nil {
+		return errorResult(err)
+	}
+	return nil
+}
+
+func _bi_Close(frame Frame, parameters []Node) *CallResult {
+
+	name, err := evalToWord(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	err = frame.workspace().files.CloseFile(name)
+	if err != nil {
+		return errorResult(err)
+	}
+	return nil
+}
+
+func _bi_AllOpen(frame Frame, parameters []Node) *CallResult {
+
+	var fn Node
+	var ln Node
+	for _, name := range frame.workspace().files.OpenFileNames() {
+		n := newWordNode(-1, -1, name, true)
+		if fn == nil {
+			fn = n
+		} else {
+			ln.addNode(n)
+		}
+		ln = n
+	}
+
+	return returnResult(newListNode(-1, -1, fn))
+}
+
+func _bi_CloseAll(frame Frame, parameters []Node) *CallResult {
+
+	frame.workspace().files.CloseAll()
+	return nil
+}
+
+func _bi_SetRead(frame Frame, parameters []Node) *CallResult {
+
+	name, err := evalToStreamName(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	err = frame.workspace().files.SetReader(name)
+	if err != nil {
+		return errorResult(err)
+	}
+	return nil
+}
+
+func _bi_SetWrite(frame Frame, parameters []Node) *CallResult {
+
+	name, err := evalToStreamName(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	err = frame.workspace().files.SetWriter(name)
+	if err != nil {
+		return errorResult(err)
+	}
+	return nil
+}
+
+func _bi_Reader(frame Frame, parameters []Node) *CallResult {
+
+	return returnResult(streamNameNode(frame.workspace().files.reader))
+}
+
+func _bi_Writer(frame Frame, parameters []Node) *CallResult {
+
+	return returnResult(streamNameNode(frame.workspace().files.writer))
+}
+
+func _bi_ReadPos(frame Frame, parameters []Node) *CallResult {
+
+	f, ok := frame.workspace().files.reader.(*NormalFile)
+	if !ok {
+		return errorResult(errorStreamNotFile(nil, "READER"))
+	}
+	return returnResult(newWordNode(-1, -1, fmt.Sprint(f.ReadPos()), true))
+}
+
+func _bi_SetReadPos(frame Frame, parameters []Node) *CallResult {
+
+	n, err := evalToNumber(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+	if n < 0 {
+		return errorResult(errorBadInput(parameters[0]))
+	}
+
+	f, ok := frame.workspace().files.reader.(*NormalFile)
+	if !ok {
+		return errorResult(errorStreamNotFile(parameters[0], "READER"))
+	}
+	f.SetReadPos(int64(n))
+	return nil
+}
+
+func _bi_WritePos(frame Frame, parameters []Node) *CallResult {
+
+	f, ok := frame.workspace().files.writer.(*NormalFile)
+	if !ok {
+		return errorResult(errorStreamNotFile(nil, "WRITER"))
+	}
+	return returnResult(newWordNode(-1, -1, fmt.Sprint(f.WritePos()), true))
+}
+
+func _bi_SetWritePos(frame Frame, parameters []Node) *CallResult {
+
+	n, err := evalToNumber(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+	if n < 0 {
+		return errorResult(errorBadInput(parameters[0]))
+	}
+
+	f, ok := frame.workspace().files.writer.(*NormalFile)
+	if !ok {
+		return errorResult(errorStreamNotFile(parameters[0], "WRITER"))
+	}
+	f.SetWritePos(int64(n))
+	return nil
+}
+
+func _bi_FileLen(frame Frame, parameters []Node) *CallResult {
+
+	name, err := evalToWord(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	f, err := frame.workspace().files.GetFile(name)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	nf, ok := f.(*NormalFile)
+	if !ok {
+		return errorResult(errorStreamNotFile(parameters[0], name))
+	}
+
+	l, err := nf.Len()
+	if err != nil {
+		return errorResult(err)
+	}
+	return returnResult(newWordNode(-1, -1, fmt.Sprint(l), true))
+}
+
+func _bi_Dribble(frame Frame, parameters []Node) *CallResult {
+
+	name, err := evalToWord(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	err = frame.workspace().files.Dribble(name)
+	if err != nil {
+		return errorResult(err)
+	}
+	return nil
+}
+
+func _bi_NoDribble(frame Frame, parameters []Node) *CallResult {
+
+	err := frame.workspace().files.NoDribble()
+	if err != nil {
+		return errorResult(err)
+	}
+	return nil
+}
+
 func _bi_Ascii(frame Frame, parameters []Node) *CallResult {
 
 	v, err := evalToWord(parameters[0])
@@ -1840,7 +2248,29 @@ func _bi_Throw(frame Frame, parameters []Node) *CallResult {
 		return errorResult(err)
 	}
 
-	return errorResult(userError(v))
+	var value Node
+	if len(parameters) > 1 {
+		value = parameters[1]
+	}
+
+	if strings.ToUpper(v) == keywordError {
+		msg := "Throw \"Error"
+		if value != nil {
+			msg = value.String()
+			if ln, ok := value.(*ListNode); ok {
+				msg = ""
+				for n := ln.firstChild; n != nil; n = n.next() {
+					if msg != "" {
+						msg += " "
+					}
+					msg += n.String()
+				}
+			}
+		}
+		return errorResult(errorThrown(frame.caller(), msg))
+	}
+
+	return errorResult(throwError(v, value))
 }
 
 func _bi_Catch(frame Frame, parameters []Node) *CallResult {
@@ -1850,26 +2280,106 @@ func _bi_Catch(frame Frame, parameters []Node) *CallResult {
 		return errorResult(err)
 	}
 
+	uExpected := strings.ToUpper(v)
+
+	ws := frame.workspace()
+	if uExpected == keywordError {
+		ws.errorCatchers++
+	}
 	rv := evalInstructionList(frame, parameters[1], true)
-	if !rv.hasError() {
+	if uExpected == keywordError {
+		ws.errorCatchers--
+	}
+	if rv == nil || !rv.hasError() {
 		return rv
 	}
 
-	uExpected := strings.ToUpper(v)
+	te, isThrow := rv.err.(*ThrowError)
+	if isThrow {
+		if uExpected != strings.ToUpper(te.tag) {
+			return rv
+		}
+		if te.value == nil {
+			return nil
+		}
+		return returnResult(te.value)
+	}
 
-	if uExpected == keywordError || uExpected == strings.ToUpper(rv.err.Error()) {
+	_, isContinue := rv.err.(*continueSignal)
+	if uExpected == keywordError && !isContinue {
+		ws.lastError = toLogoError(rv.err)
 		return nil
 	}
 
 	return rv
 }
 
+func _bi_Error(frame Frame, parameters []Node) *CallResult {
+
+	ws := frame.workspace()
+	le := ws.lastError
+	if le == nil {
+		return returnResult(newListNode(-1, -1, nil))
+	}
+	ws.lastError = nil
+
+	var fn Node
+	var ln Node
+	for _, w := range strings.Fields(le.message) {
+		n := newWordNode(-1, -1, w, true)
+		if fn == nil {
+			fn = n
+		} else {
+			ln.addNode(n)
+		}
+		ln = n
+	}
+
+	var pn Node = newListNode(-1, -1, nil)
+	if le.procedure != "" {
+		pn = newWordNode(-1, -1, le.procedure, true)
+	}
+
+	var lineNode Node = newListNode(-1, -1, nil)
+	if le.hasPosition() {
+		lineNode = newWordNode(-1, -1, fmt.Sprint(le.line), true)
+	}
+
+	cn := newWordNode(-1, -1, fmt.Sprint(le.code), true)
+	mn := newListNode(-1, -1, fn)
+	cn.addNode(mn)
+	mn.addNode(pn)
+	pn.addNode(lineNode)
+
+	return returnResult(newListNode(-1, -1, cn))
+}
+
 func registerBuiltInProcedures(workspace *Workspace) {
 
-	workspace.registerBuiltIn("OUTPUT", "OP", 1, _bi_Output)
+	workspace.registerBuiltIn(keywordOutput, "OP", 1, _bi_Output)
 	workspace.registerBuiltIn("STOP", "", 0, _bi_Stop)
 	workspace.registerBuiltIn("CATCH", "", 2, _bi_Catch)
-	workspace.registerBuiltIn("THROW", "", 1, _bi_Throw)
+	workspace.registerBuiltInWithVarParams("THROW", "", 1, _bi_Throw)
+	workspace.registerBuiltIn("ERROR", "", 0, _bi_Error)
+	workspace.registerBuiltIn("PAUSE", "", 0, _bi_Pause)
+	workspace.registerBuiltInWithVarParams(keywordContinue, "CO", 0, _bi_Continue)
+	workspace.registerBuiltIn("ERRPAUSE", "", 0, _bi_ErrPause)
+	workspace.registerBuiltIn("NOERRPAUSE", "", 0, _bi_NoErrPause)
+
+	workspace.registerBuiltIn("APPLY", "", 2, _bi_Apply)
+	workspace.registerBuiltInWithVarParams("INVOKE", "", 2, _bi_Invoke)
+	workspace.registerBuiltInWithVarParams("FOREACH", "", 2, _bi_Foreach)
+	workspace.registerBuiltInWithVarParams("MAP", "", 2, _bi_Map)
+	workspace.registerBuiltIn("FILTER", "", 2, _bi_Filter)
+	workspace.registerBuiltIn("FIND", "", 2, _bi_Find)
+	workspace.registerBuiltIn("REDUCE", "", 2, _bi_Reduce)
+	workspace.registerBuiltInWithVarParams("CROSSMAP", "", 2, _bi_Crossmap)
+	workspace.registerBuiltIn("?", "", 0, templateSlot(templateSlotName(1)))
+	for ix := 1; ix <= 9; ix++ {
+		workspace.registerBuiltIn(templateSlotName(ix), "", 0, templateSlot(templateSlotName(ix)))
+	}
+	workspace.registerBuiltIn(templateRest, "", 0, templateSlot(templateRest))
+	workspace.registerBuiltIn(templateCount, "", 0, templateSlot(templateCount))
 
 	workspace.registerBuiltInWithVarParams("PRINT", "PR", 1, _bi_Print)
 	workspace.registerBuiltInWithVarParams("SHOW", "", 1, _bi_FPrint)
@@ -1882,6 +2392,15 @@ func registerBuiltInProcedures(workspace *Workspace) {
 	workspace.registerBuiltIn("REQUEST", "", 0, _bi_Request)
 
 	workspace.registerBuiltIn("REPEAT", "", 2, _bi_Repeat)
+	workspace.registerBuiltIn("FOREVER", "", 1, _bi_Forever)
+	workspace.registerBuiltIn("REPCOUNT", "", 0, _bi_RepCount)
+	workspace.registerBuiltIn("SETRECURSIONLIMIT", "", 1, _bi_SetRecursionLimit)
+	workspace.registerBuiltIn("RECURSIONLIMIT", "", 0, _bi_RecursionLimit)
+	workspace.registerBuiltIn("FOR", "", 2, _bi_For)
+	workspace.registerBuiltIn("WHILE", "", 2, _bi_While)
+	workspace.registerBuiltIn("UNTIL", "", 2, _bi_Until)
+	workspace.registerBuiltIn("DO.WHILE", "", 2, _bi_DoWhile)
+	workspace.registerBuiltIn("DO.UNTIL", "", 2, _bi_DoUntil)
 	workspace.registerBuiltIn(keywordIf, "", 2, _bi_If)
 
 	workspace.registerBuiltInWithVarParams("SUM", "", 2, _bi_Sum)
@@ -1944,18 +2463,34 @@ func registerBuiltInProcedures(workspace *Workspace) {
 	workspace.registerBuiltIn("EMPTYP", "", 1, _bi_Emptyp)
 	workspace.registerBuiltIn("WORDP", "", 1, _bi_Wordp)
 	workspace.registerBuiltIn("LISTP", "", 1, _bi_Listp)
+	workspace.registerBuiltIn("ARRAYP", "", 1, _bi_Arrayp)
 	workspace.registerBuiltIn("SENTENCEP", "", 1, _bi_Sentencep)
 	workspace.registerBuiltIn("MEMBERP", "", 2, _bi_Memberp)
 	workspace.registerBuiltIn("ITEM", "NTH", 2, _bi_Item)
+	workspace.registerBuiltIn("MDITEM", "", 2, _bi_MdItem)
+	workspace.registerBuiltInWithVarParams("ARRAY", "", 1, _bi_Array)
+	workspace.registerBuiltInWithVarParams("MDARRAY", "", 1, _bi_MdArray)
+	workspace.registerBuiltInWithVarParams("LISTTOARRAY", "", 1, _bi_ListToArray)
+	workspace.registerBuiltIn("ARRAYTOLIST", "", 1, _bi_ArrayToList)
+	workspace.registerBuiltIn("SETITEM", "", 3, _bi_SetItem)
+	workspace.registerBuiltIn("MDSETITEM", "", 3, _bi_MdSetItem)
 
 	workspace.registerBuiltInWithVarParams("AND", "", 2, _bi_Both)
 	workspace.registerBuiltInWithVarParams("OR", "", 2, _bi_Either)
 	workspace.registerBuiltInWithVarParams("NOT", "", 1, _bi_Not)
 
-	workspace.registerBuiltIn("TRACE", "", 0, _bi_Trace)
-	workspace.registerBuiltIn("UNTRACE", "", 0, _bi_Untrace)
+	workspace.registerBuiltIn("TRACE", "", 1, _bi_Trace)
+	workspace.registerBuiltIn("UNTRACE", "", 1, _bi_Untrace)
 	workspace.registerBuiltIn("STEP", "", 1, _bi_Step)
 	workspace.registerBuiltIn("UNSTEP", "", 1, _bi_Unstep)
+	workspace.registerBuiltIn("BREAK", "", 2, _bi_Break)
+	workspace.registerBuiltInWithVarParams("UNBREAK", "", 1, _bi_Unbreak)
+	workspace.registerBuiltIn("WATCH", "", 1, _bi_Watch)
+	workspace.registerBuiltIn("UNWATCH", "", 1, _bi_Unwatch)
+	workspace.registerBuiltIn("BACKTRACE", "", 0, _bi_Backtrace)
+	workspace.registerBuiltIn("PROFILE", "", 0, _bi_Profile)
+	workspace.registerBuiltIn("NOPROFILE", "", 0, _bi_NoProfile)
+	workspace.registerBuiltInWithVarParams("PROFILEREPORT", "", 0, _bi_ProfileReport)
 	workspace.registerBuiltIn("GOODBYE", "BYE", 0, _bi_Goodbye)
 	workspace.registerBuiltIn("WAIT", "", 1, _bi_Wait)
 
@@ -1969,6 +2504,19 @@ func registerBuiltInProcedures(workspace *Workspace) {
 	workspace.registerBuiltIn("POT", "", 1, _bi_Pot)
 	workspace.registerBuiltIn("POTS", "", 0, _bi_Pots)
 
+	workspace.registerBuiltIn("DEFINE", "", 2, _bi_Define)
+	workspace.registerBuiltIn("PROCTEXT", "", 1, _bi_ProcText)
+	workspace.registerBuiltIn(".DEFMACRO", "", 2, _bi_DefMacro)
+	workspace.registerBuiltIn("COPYDEF", "", 2, _bi_CopyDef)
+	workspace.registerBuiltIn("DEFINEDP", "DEFINED?", 1, _bi_Definedp)
+	workspace.registerBuiltIn("PRIMITIVEP", "PRIMITIVE?", 1, _bi_Primitivep)
+	workspace.registerBuiltIn("MACROP", "MACRO?", 1, _bi_Macrop)
+	workspace.registerBuiltIn("ARITY", "", 1, _bi_Arity)
+	workspace.registerBuiltIn("PROCEDURES", "", 0, _bi_Procedures)
+	workspace.registerBuiltIn("PRIMITIVES", "", 0, _bi_Primitives)
+	workspace.registerBuiltIn("NAMES", "", 0, _bi_Names)
+	workspace.registerBuiltIn("CONTENTS", "", 0, _bi_Contents)
+
 	workspace.registerBuiltIn("ERALL", "", 0, _bi_ErAll)
 	workspace.registerBuiltIn("ERASE", "", 1, _bi_Erase)
 	workspace.registerBuiltIn("ERN", "", 1, _bi_Ern)
@@ -1995,6 +2543,22 @@ func registerBuiltInProcedures(workspace *Workspace) {
 	workspace.registerBuiltIn("RENAME", "", 2, _bi_Rename)
 	workspace.registerBuiltIn("POFILE", "", 1, _bi_Pofile)
 
+	workspace.registerBuiltIn("OPEN", "", 1, _bi_Open)
+	workspace.registerBuiltIn("CLOSE", "", 1, _bi_Close)
+	workspace.registerBuiltIn("ALLOPEN", "", 0, _bi_AllOpen)
+	workspace.registerBuiltIn("CLOSEALL", "", 0, _bi_CloseAll)
+	workspace.registerBuiltIn("SETREAD", "", 1, _bi_SetRead)
+	workspace.registerBuiltIn("SETWRITE", "", 1, _bi_SetWrite)
+	workspace.registerBuiltIn("READER", "", 0, _bi_Reader)
+	workspace.registerBuiltIn("WRITER", "", 0, _bi_Writer)
+	workspace.registerBuiltIn("READPOS", "", 0, _bi_ReadPos)
+	workspace.registerBuiltIn("SETREADPOS", "", 1, _bi_SetReadPos)
+	workspace.registerBuiltIn("WRITEPOS", "", 0, _bi_WritePos)
+	workspace.registerBuiltIn("SETWRITEPOS", "", 1, _bi_SetWritePos)
+	workspace.registerBuiltIn("FILELEN", "", 1, _bi_FileLen)
+	workspace.registerBuiltIn("DRIBBLE", "", 1, _bi_Dribble)
+	workspace.registerBuiltIn("NODRIBBLE", "", 0, _bi_NoDribble)
+
 	workspace.registerBuiltIn("GO", "", 1, _bi_Go)
 	workspace.registerBuiltIn("LABEL", "", 1, _bi_Label)
 
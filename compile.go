@@ -0,0 +1,538 @@
+package main
+
+import (
+	"strings"
+)
+
+// Procedure bodies, and the instruction lists written inside them, are
+// compiled into trees of calls whose procedures, input counts and infix
+// precedence are resolved once rather than on every execution. Compiled
+// code is discarded whenever a procedure is defined or erased. Anything
+// the compiler can't resolve is left to the interpreter. The two are meant
+// to give the same results and the tests run every expression both ways,
+// but the interpreter remains the reference when they disagree.
+
+// compileCode can be turned off to run everything through the interpreter.
+var compileCode = true
+
+const (
+	codeValue = iota
+	codeThing
+	codeCall
+	codeInfix
+	codeGroup
+	codeMinus
+	codeGo
+)
+
+type code struct {
+	kind   int
+	node   Node
+	caller *WordNode
+	proc   Procedure
+	args   []*code
+	block  *compiledBlock
+	name   string
+	last   bool
+	nested bool
+	output bool
+}
+
+type compiledBlock struct {
+	gen        int
+	ok         bool
+	statements []*code
+	starts     []Node
+	labels     map[Node]int
+}
+
+type compiler struct {
+	ws    *Workspace
+	lists []*ListNode
+}
+
+func compileInstructions(ws *Workspace, node Node) *compiledBlock {
+
+	c := &compiler{ws, nil}
+	b, ok := c.block(node)
+	if !ok {
+		return &compiledBlock{ws.generation, false, nil, nil, nil}
+	}
+
+	for _, ln := range c.lists {
+		_, exists := ws.listCode[ln]
+		if !exists {
+			ws.listCode[ln] = nil
+		}
+	}
+	return b
+}
+
+func (this *compiler) block(node Node) (*compiledBlock, bool) {
+
+	b := &compiledBlock{this.ws.generation, true, make([]*code, 0, 4), make([]Node, 0, 4), nil}
+	n := node
+	for n != nil {
+		var c *code
+		start := n
+		ok := true
+		switch nn := n.(type) {
+		case *ListNode, *ArrayNode:
+			c = this.value(nn)
+			n = n.next()
+		case *GroupNode:
+			gb, gok := this.block(nn.firstChild)
+			c = &code{kind: codeGroup, node: nn, block: gb}
+			ok = gok
+			n = n.next()
+		case *WordNode:
+			uv := strings.ToUpper(nn.value)
+			if uv == keywordGo {
+				c, n, ok = this.call(nn, false, true)
+				if ok {
+					c.kind = codeGo
+				}
+			} else {
+				c, n, ok = this.expression(n)
+			}
+			if ok && uv == keywordLabel {
+				if b.labels == nil {
+					b.labels = make(map[Node]int)
+				}
+				b.labels[start] = len(b.statements)
+			}
+		}
+		if !ok {
+			return nil, false
+		}
+		b.statements = append(b.statements, c)
+		b.starts = append(b.starts, start)
+	}
+
+	return b, true
+}
+
+func (this *compiler) value(node Node) *code {
+
+	ln, isList := node.(*ListNode)
+	if isList {
+		this.lists = append(this.lists, ln)
+	}
+	return &code{kind: codeValue, node: node}
+}
+
+// expression follows the same rules as evaluateExpression, building the
+// tree that it would have evaluated.
+func (this *compiler) expression(n Node) (*code, Node, bool) {
+
+	nl := make([]*code, 0, 2)
+	ops := make([]*WordNode, 0, 2)
+	opIx := make([]int, 0, 2)
+	expectOp := false
+	prevIx := -2
+
+	reduce := func() bool {
+		ix := opIx[len(opIx)-1]
+		wn := ops[len(ops)-1]
+		nlc := len(nl)
+		if nlc < 2 {
+			return false
+		}
+		procName := infixProc[ix]
+		proc := this.ws.findProcedure(procName)
+		if proc == nil {
+			return false
+		}
+		l, c := wn.position()
+		caller := newWordNode(l, c, procName, false)
+		nl = append(nl[0:nlc-2], &code{kind: codeInfix, node: wn, caller: caller, proc: proc, args: []*code{nl[nlc-2], nl[nlc-1]}})
+		ops = ops[0 : len(ops)-1]
+		opIx = opIx[0 : len(opIx)-1]
+		return true
+	}
+
+	exit := false
+	for !exit && n != nil {
+		switch nn := n.(type) {
+		case *GroupNode:
+			if expectOp {
+				exit = true
+			} else {
+				b, ok := this.block(nn.firstChild)
+				if !ok {
+					return nil, nil, false
+				}
+				nl = append(nl, &code{kind: codeGroup, node: nn, block: b, nested: true})
+				expectOp = true
+				n = n.next()
+			}
+		case *WordNode:
+			ix := getInfixOp(nn.value)
+			if ix >= 0 {
+				if nn.value == "-" && (prevIx == -2 || (prevIx >= 0 && prevIx != 5)) && nn.next() != nil {
+					c, next, ok := this.expression(nn.next())
+					if !ok {
+						return nil, nil, false
+					}
+					nl = append(nl, &code{kind: codeMinus, node: nn, args: []*code{c}})
+					n = next
+					expectOp = true
+				} else {
+					if infixProc[ix] == "" {
+						return nil, nil, false
+					}
+					for len(opIx) > 0 && infixPrec[ix] <= infixPrec[opIx[len(opIx)-1]] {
+						if !reduce() {
+							return nil, nil, false
+						}
+					}
+					ops = append(ops, nn)
+					opIx = append(opIx, ix)
+					n = n.next()
+					expectOp = false
+				}
+			} else {
+				if expectOp {
+					exit = true
+				} else if !nn.isLiteral {
+					c, next, ok := this.call(nn, len(nl) > 0 || len(ops) > 0, false)
+					if !ok {
+						return nil, nil, false
+					}
+					nl = append(nl, c)
+					n = next
+					expectOp = true
+				} else {
+					nl = append(nl, this.value(nn.datum()))
+					n = n.next()
+					expectOp = true
+				}
+			}
+			prevIx = ix
+		case *ArrayNode:
+			if expectOp {
+				exit = true
+			} else {
+				nl = append(nl, this.value(nn))
+				n = n.next()
+				expectOp = true
+			}
+		case *ListNode:
+			exit = true
+		}
+	}
+
+	for len(opIx) > 0 {
+		if !reduce() {
+			return nil, nil, false
+		}
+	}
+
+	if len(nl) != 1 {
+		return nil, nil, false
+	}
+	return nl[0], n, true
+}
+
+// call follows callProcedure and fetchParameters. GO is only compiled as
+// a statement of its own, where the jump can be made within the block.
+func (this *compiler) call(wn *WordNode, nested, statement bool) (*code, Node, bool) {
+
+	if wn.value[0] == ':' {
+		return &code{kind: codeThing, node: wn, name: wn.value[1:]}, wn.next(), true
+	}
+
+	procName := strings.ToUpper(wn.value)
+	proc := this.ws.findProcedure(procName)
+	if proc == nil || procName == keywordEdit || isContinue(proc) || (procName == keywordGo && !statement) {
+		return nil, nil, false
+	}
+
+	c := &code{kind: codeCall, node: wn, caller: wn, proc: proc, nested: nested}
+	bp, isBuiltIn := proc.(*BuiltInProcedure)
+	c.output = isBuiltIn && bp.name == keywordOutput
+
+	n := wn.next()
+	varParams := proc.allowVarParameters() && wn.isFirstOfGroup
+	if proc.parameterCount() > 0 || (varParams && n != nil) {
+		paramCount := proc.parameterCount()
+		if varParams {
+			paramCount = -1
+		}
+
+		c.args = make([]*code, 0, proc.parameterCount())
+		for {
+			if n == nil {
+				return nil, nil, false
+			}
+			if n.nodeType() == List {
+				c.args = append(c.args, this.value(n))
+				n = n.next()
+			} else {
+				var a *code
+				var ok bool
+				a, n, ok = this.expression(n)
+				if !ok {
+					return nil, nil, false
+				}
+				c.args = append(c.args, a)
+			}
+			if (paramCount > 0 && len(c.args) == paramCount) || n == nil {
+				break
+			}
+		}
+		if len(c.args) < paramCount {
+			return nil, nil, false
+		}
+
+		if procName == keywordIf && n != nil && n.nodeType() == List {
+			c.args = append(c.args, this.value(n))
+			n = n.next()
+		}
+	}
+
+	c.last = n == nil
+	return c, n, true
+}
+
+func (this *compiledBlock) run(frame Frame, canReturnValue bool) *CallResult {
+
+	ws := frame.workspace()
+	intFrame, _ := findInterpretedFrame(frame)
+
+	var lastValue Node = nil
+	var rv *CallResult = nil
+	for ix := 0; ix < len(this.statements); ix++ {
+		if this.gen != ws.generation {
+			// Definitions changed under us, so the rest is interpreted.
+			return evalNodeStream(frame, this.starts[ix], canReturnValue)
+		}
+
+		c := this.statements[ix]
+		switch c.kind {
+		case codeValue:
+			if c.node.nodeType() != Word {
+				if !canReturnValue {
+					return errorResult(errorWordExpected(c.node))
+				}
+				lastValue = c.node
+				rv = nil
+				continue
+			}
+			rv = returnResult(c.node)
+		case codeGo:
+			var params []Node
+			params, rv = c.parameters(frame)
+			if rv != nil {
+				return rv
+			}
+			ln, err := findLabel(frame, c.node, params[0])
+			if err != nil {
+				return errorResult(err)
+			}
+			lx, found := this.labels[ln]
+			if !found {
+				return evalNodeStream(frame, ln, canReturnValue)
+			}
+			ix = lx - 1
+		default:
+			rv = c.eval(frame)
+		}
+
+		if rv != nil {
+			if rv.hasError() {
+				return rv
+			}
+			lastValue = rv.returnValue
+		}
+
+		if rv != nil && rv.shouldStop() {
+			break
+		}
+
+		if intFrame != nil && intFrame.stopped {
+			break
+		}
+	}
+
+	if canReturnValue && lastValue != nil {
+		return returnResult(lastValue)
+	}
+	return nil
+}
+
+func (this *code) eval(frame Frame) *CallResult {
+
+	switch this.kind {
+	case codeValue:
+		return returnResult(this.node)
+
+	case codeThing:
+		val := frame.getVars().getVariable(frame, this.name)
+		if val == nil {
+			return errorResult(errorVariableNotFound(this.node, this.name))
+		}
+		return returnResult(val)
+
+	case codeGroup:
+		if this.nested {
+			enterArgs(frame)
+			defer leaveArgs(frame)
+		}
+		return this.block.run(frame, true)
+
+	case codeMinus:
+		enterArgs(frame)
+		rv := this.args[0].eval(frame)
+		leaveArgs(frame)
+		if rv != nil && rv.shouldStop() {
+			return rv
+		}
+		if rv == nil || rv.returnValue == nil {
+			return errorResult(errorNotEnoughParameters(this.node.(*WordNode), this.node))
+		}
+		v, err := evalToNumeric(rv.returnValue)
+		if err != nil {
+			return errorResult(err)
+		}
+		return returnResult(createNumberNode(negateNumeric(v)))
+
+	case codeInfix:
+		params := make([]Node, 2)
+		for ix, a := range this.args {
+			rv := a.eval(frame)
+			if rv != nil && rv.shouldStop() {
+				return rv
+			}
+			if rv == nil || rv.returnValue == nil {
+				return errorResult(errorNotEnoughParameters(this.caller, this.caller))
+			}
+			params[ix] = rv.returnValue
+		}
+		return this.invoke(frame, params)
+
+	case codeCall:
+		if this.nested {
+			enterArgs(frame)
+			defer leaveArgs(frame)
+		}
+		params, rv := this.parameters(frame)
+		if rv != nil {
+			return rv
+		}
+		return this.invoke(frame, params)
+	}
+
+	return nil
+}
+
+// parameters evaluates the inputs to a call, with the same tail context
+// that callProcedure gives them.
+func (this *code) parameters(frame Frame) ([]Node, *CallResult) {
+
+	if len(this.args) == 0 {
+		return []Node{}, nil
+	}
+
+	outputTail := this.output && isTailTransparent(frame)
+	if outputTail {
+		tc := frame.tailInfo()
+		saved := *tc
+		tc.tail = true
+		tc.argDepth = 0
+		defer func() { *tc = saved }()
+	} else {
+		enterArgs(frame)
+		defer leaveArgs(frame)
+	}
+
+	params := make([]Node, len(this.args))
+	for ix, a := range this.args {
+		if a.kind == codeValue {
+			params[ix] = a.node
+			continue
+		}
+		rv := a.eval(frame)
+		if rv != nil && rv.shouldStop() {
+			return nil, rv
+		}
+		if rv == nil || rv.returnValue == nil {
+			return nil, errorResult(errorNotEnoughParameters(this.caller, this.caller.next()))
+		}
+		params[ix] = rv.returnValue
+	}
+
+	if outputTail && isFrameStopped(frame) {
+		return nil, stopResult()
+	}
+	return params, nil
+}
+
+func (this *code) invoke(frame Frame, parameters []Node) *CallResult {
+
+	ws := frame.workspace()
+	ip, isInterpreted := this.proc.(*InterpretedProcedure)
+	if isInterpreted {
+		if this.last && !ip.macro {
+			tf := tailCallFrame(frame, nil)
+			if tf != nil {
+				tf.setTailCall(ip, this.caller, parameters)
+				return stopResult()
+			}
+		}
+		if frame.depth() >= ws.maxDepth {
+			return errorResult(errorTooManyLevels(this.caller, ip.name))
+		}
+	}
+
+	subFrame := this.proc.createFrame(frame, this.caller)
+	ws.currentFrame = subFrame
+	defer func() {
+		ws.currentFrame = frame
+	}()
+
+	bf, isBuiltIn := subFrame.(*BuiltInFrame)
+	if isBuiltIn && this.last && tailTransparent[bf.name] && tailCallFrame(frame, nil) != nil {
+		bf.tc.tail = true
+	}
+
+	rv := subFrame.eval(parameters)
+	if isInterpreted && ip.macro {
+		rv = expandMacro(frame, this.caller, rv)
+	}
+	return rv
+}
+
+// compiledBody returns the compiled form of the procedure's body, or nil
+// if it has to be interpreted.
+func (this *InterpretedProcedure) compiledBody(ws *Workspace) *compiledBlock {
+
+	if !compileCode || this.step || this.firstNode == nil || len(this.breaks) > 0 {
+		return nil
+	}
+	if this.code == nil || this.code.gen != ws.generation {
+		this.code = compileInstructions(ws, this.firstNode)
+	}
+	if !this.code.ok {
+		return nil
+	}
+	return this.code
+}
+
+// compiledList returns the compiled form of an instruction list written
+// inside a compiled procedure body, or nil if it has to be interpreted.
+func (this *Workspace) compiledList(ln *ListNode) *compiledBlock {
+
+	b, exists := this.listCode[ln]
+	if !compileCode || !exists {
+		return nil
+	}
+	if b == nil {
+		b = compileInstructions(this, ln.firstChild)
+		this.listCode[ln] = b
+	}
+	if !b.ok {
+		return nil
+	}
+	return b
+}
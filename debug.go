@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// procedureLine returns the line of n counted from the title line of the
+// procedure, which is line 1.
+func (this *InterpretedProcedure) procedureLine(n Node) int {
+	l, _ := n.position()
+	return l - this.firstLine + 1
+}
+
+// checkBreak pauses before the first call made on a line with a
+// breakpoint. Further calls on the same line do not break again.
+func (this *InterpretedFrame) checkBreak(frame Frame, wn *WordNode) *CallResult {
+
+	l := this.procedure.procedureLine(wn)
+	if l == this.breakLine {
+		return nil
+	}
+	this.breakLine = l
+	if !this.procedure.breaks[l] {
+		return nil
+	}
+
+	this.ws.print(fmt.Sprintf("Break at %s line %d.\n", this.procedure.name, l))
+	rv := pause(frame)
+	this.breakLine = l
+	if rv != nil && rv.shouldStop() {
+		return rv
+	}
+	return nil
+}
+
+func watchHit(frame Frame, v *Variable, old Node) {
+
+	if old != nil && nodesEqual(old, v.value, false) {
+		return
+	}
+
+	var b bytes.Buffer
+	b.WriteString(v.name + " changed")
+	if old != nil {
+		b.WriteString(" from ")
+		nodeToText(&b, old, true)
+	}
+	b.WriteString(" to ")
+	nodeToText(&b, v.value, true)
+	procFrame, _ := findInterpretedFrame(frame)
+	if procFrame != nil {
+		b.WriteString(" in " + procFrame.procedure.name)
+	}
+	b.WriteString(".\n")
+
+	frame.workspace().print(b.String())
+	pause(frame)
+}
+
+// inputNames returns the names of all the inputs of a procedure in order.
+func (this *InterpretedProcedure) inputNames() []string {
+
+	names := make([]string, 0, len(this.parameters)+len(this.optional)+1)
+	names = append(names, this.parameters...)
+	for _, o := range this.optional {
+		names = append(names, o.name)
+	}
+	if this.rest != "" {
+		names = append(names, this.rest)
+	}
+	return names
+}
+
+// describeCall returns a call to p with the values of its inputs, followed
+// by where the call was made.
+func describeCall(p *InterpretedProcedure, values []Node, caller *WordNode, count int) string {
+
+	var b bytes.Buffer
+	b.WriteString(p.name)
+	for ix, name := range p.inputNames() {
+		if ix < len(values) && values[ix] != nil {
+			b.WriteString(" :" + name + " ")
+			nodeToText(&b, values[ix], true)
+		}
+	}
+
+	if caller != nil && caller.line >= 0 {
+		b.WriteString(fmt.Sprintf(" (%d,%d)", caller.line, caller.col))
+	}
+	if count > 1 {
+		b.WriteString(fmt.Sprintf(" [%d tail calls]", count))
+	}
+	return b.String()
+}
+
+// describe returns the call made to the frame's procedure with the current
+// values of its inputs.
+func (this *InterpretedFrame) describe() string {
+
+	names := this.procedure.inputNames()
+	values := make([]Node, len(names))
+	for ix, name := range names {
+		v, exists := this.vars.vars[strings.ToUpper(name)]
+		if exists {
+			values[ix] = v.value
+		}
+	}
+	return describeCall(this.procedure, values, this.callerNode, 1)
+}
+
+// describe returns a call whose frame was reused, with the inputs it was
+// given.
+func (this *replacedCall) describe() string {
+
+	p := this.procedure
+	n := len(p.parameters) + len(p.optional)
+	values := make([]Node, n, n+1)
+	copy(values, this.parameters)
+	if p.rest != "" {
+		if len(this.parameters) > n {
+			values = append(values, nodesToList(this.parameters[n:]))
+		} else {
+			values = append(values, newListNode(-1, -1, nil))
+		}
+	}
+	return describeCall(p, values, this.caller, this.count)
+}
+
+func _bi_Backtrace(frame Frame, parameters []Node) *CallResult {
+
+	ws := frame.workspace()
+	for f := frame; f != nil; f = f.parentFrame() {
+		switch pf := f.(type) {
+		case *InterpretedFrame:
+			ws.print(pf.describe() + "\n")
+			for ix := len(pf.replaced) - 1; ix >= 0; ix-- {
+				ws.print(pf.replaced[ix].describe() + "\n")
+			}
+		}
+	}
+
+	return nil
+}
+
+func _bi_Break(frame Frame, parameters []Node) *CallResult {
+
+	name, err := evalToWord(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+	line, err := evalToInteger(parameters[1])
+	if err != nil {
+		return errorResult(err)
+	}
+	if line < 1 {
+		return errorResult(errorPositiveIntegerExpected(parameters[1]))
+	}
+
+	ws := frame.workspace()
+	switch p := ws.findProcedure(strings.ToUpper(name)).(type) {
+	case *InterpretedProcedure:
+		if p.breaks == nil {
+			p.breaks = make(map[int]bool)
+		}
+		p.breaks[line] = true
+		ws.invalidateCode()
+	case *BuiltInProcedure:
+		return errorResult(errorProcIsBuiltIn(parameters[0], name))
+	default:
+		return errorResult(errorProcedureNotFound(parameters[0], name))
+	}
+
+	return nil
+}
+
+func _bi_Unbreak(frame Frame, parameters []Node) *CallResult {
+
+	name, err := evalToWord(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	ws := frame.workspace()
+	p, ok := ws.findProcedure(strings.ToUpper(name)).(*InterpretedProcedure)
+	if !ok {
+		return errorResult(errorProcedureNotFound(parameters[0], name))
+	}
+
+	if len(parameters) > 1 {
+		line, err := evalToInteger(parameters[1])
+		if err != nil {
+			return errorResult(err)
+		}
+		delete(p.breaks, line)
+	} else {
+		p.breaks = nil
+	}
+	ws.invalidateCode()
+
+	return nil
+}
+
+func _bi_Watch(frame Frame, parameters []Node) *CallResult {
+
+	names, err := toWordList(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	ws := frame.workspace()
+	for _, n := range names {
+		ws.watches[strings.ToUpper(n.value)] = true
+	}
+
+	return nil
+}
+
+func _bi_Unwatch(frame Frame, parameters []Node) *CallResult {
+
+	names, err := toWordList(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	ws := frame.workspace()
+	for _, n := range names {
+		delete(ws.watches, strings.ToUpper(n.value))
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+)
+
+const (
+	displayOpLine = iota
+	displayOpDot
+	displayOpFill
+	displayOpText
+	displayOpPenColor
+	displayOpBackground
+	displayOpImage
+)
+
+type displayRun struct {
+	y, x1, x2 int
+}
+
+type displayOp struct {
+	op       int
+	x1, y1   int
+	x2, y2   int
+	penState int
+	c        color.RGBA
+	text     string
+	runs     []displayRun
+	img      image.Image
+}
+
+func (this *Turtle) record(op *displayOp) {
+	this.displayList = append(this.displayList, op)
+}
+
+func (this *Turtle) resetDisplayList() {
+	this.displayList = this.displayList[:0]
+	this.record(&displayOp{op: displayOpBackground, c: this.screenColor})
+	this.record(&displayOp{op: displayOpPenColor, c: this.penColor})
+}
+
+func (this *Turtle) recordLine(x1, y1, x2, y2 int) {
+	if this.penState == penStateUp {
+		return
+	}
+	if x1 == x2 && y1 == y2 {
+		this.record(&displayOp{op: displayOpDot, x1: x1, y1: y1, penState: this.penState})
+	} else {
+		this.record(&displayOp{op: displayOpLine, x1: x1, y1: y1, x2: x2, y2: y2, penState: this.penState})
+	}
+}
+
+// runBounds returns the rectangle covered by the runs of a fill.
+func runBounds(runs []displayRun) (minX, minY, maxX, maxY int) {
+
+	minX, minY, maxX, maxY = runs[0].x1, runs[0].y, runs[0].x2, runs[0].y
+	for _, r := range runs[1:] {
+		minX = intMin(minX, r.x1)
+		maxX = intMax(maxX, r.x2)
+		minY = intMin(minY, r.y)
+		maxY = intMax(maxY, r.y)
+	}
+	return
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+type svgWriter struct {
+	w       io.Writer
+	path    bytes.Buffer
+	pathCol color.RGBA
+	lx, ly  int
+}
+
+func (this *svgWriter) lineTo(c color.RGBA, x1, y1, x2, y2 int) {
+
+	if this.path.Len() > 0 && c != this.pathCol {
+		this.flush()
+	}
+	if this.path.Len() == 0 || x1 != this.lx || y1 != this.ly {
+		fmt.Fprintf(&this.path, "M%d.5 %d.5", x1, y1)
+	}
+	fmt.Fprintf(&this.path, "L%d.5 %d.5", x2, y2)
+	this.pathCol = c
+	this.lx = x2
+	this.ly = y2
+}
+
+func (this *svgWriter) flush() {
+	if this.path.Len() == 0 {
+		return
+	}
+	fmt.Fprintf(this.w, "<path d=\"%s\" fill=\"none\" stroke=\"%s\" stroke-width=\"1\" stroke-linecap=\"square\"/>\n",
+		this.path.String(), svgColor(this.pathCol))
+	this.path.Reset()
+}
+
+func (this *Turtle) writeSvg(w io.Writer, cropToVisible bool) error {
+
+	iw := this.image.W()
+	ih := this.image.H()
+	if cropToVisible && this.visW > 0 && this.visH > 0 {
+		iw = intMin(iw, this.visW)
+		ih = intMin(ih, this.visH)
+	}
+
+	bg := this.screenColor
+	for _, op := range this.displayList {
+		if op.op == displayOpBackground {
+			bg = op.c
+		}
+	}
+
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" version=\"1.1\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		iw, ih, iw, ih)
+	fmt.Fprintf(w, "<rect x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" fill=\"%s\"/>\n", iw, ih, svgColor(bg))
+
+	sw := &svgWriter{w: w}
+	pc := this.penColor
+	for _, op := range this.displayList {
+
+		// Reversed pixels depend on what was drawn underneath, which the
+		// display list doesn't know, so they are left out.
+		if op.penState == penStateReverse {
+			continue
+		}
+
+		c := pc
+		if op.penState == penStateErase {
+			c = bg
+		}
+
+		if op.op != displayOpLine {
+			sw.flush()
+		}
+
+		switch op.op {
+		case displayOpPenColor:
+			pc = op.c
+
+		case displayOpLine:
+			sw.lineTo(c, op.x1, op.y1, op.x2, op.y2)
+
+		case displayOpDot:
+			fmt.Fprintf(w, "<rect x=\"%d\" y=\"%d\" width=\"1\" height=\"1\" fill=\"%s\"/>\n",
+				op.x1, op.y1, svgColor(c))
+
+		case displayOpFill:
+			var d bytes.Buffer
+			for _, r := range op.runs {
+				fmt.Fprintf(&d, "M%d %dh%dv1h%dz", r.x1, r.y, r.x2-r.x1+1, -(r.x2 - r.x1 + 1))
+			}
+			fmt.Fprintf(w, "<path d=\"%s\" fill=\"%s\"/>\n", d.String(), svgColor(pc))
+
+		case displayOpText:
+			var t bytes.Buffer
+			xml.EscapeText(&t, []byte(op.text))
+			fmt.Fprintf(w, "<text x=\"%d\" y=\"%d\" font-family=\"monospace\" font-size=\"%d\" fill=\"%s\" xml:space=\"preserve\">%s</text>\n",
+				op.x1, op.y2-(op.y2-op.y1)/5, op.y2-op.y1, svgColor(colorWhite), t.String())
+
+		case displayOpImage:
+			var b bytes.Buffer
+			err := png.Encode(&b, op.img)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "<image x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" preserveAspectRatio=\"none\" href=\"data:image/png;base64,%s\"/>\n",
+				op.x1, op.y1, op.x2-op.x1, op.y2-op.y1, base64.StdEncoding.EncodeToString(b.Bytes()))
+		}
+	}
+	sw.flush()
+
+	_, err := fmt.Fprintf(w, "</svg>\n")
+	return err
+}
+
+func _t_SaveSvg(frame Frame, parameters []Node) *CallResult {
+
+	name, err := evalToWord(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	crop := false
+	if len(parameters) > 1 {
+		crop, err = evalToBoolean(parameters[1])
+		if err != nil {
+			return errorResult(err)
+		}
+	}
+
+	ws := frame.workspace()
+	p, err := ws.files.normPath(name)
+	if err != nil {
+		return errorResult(err)
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return errorResult(err)
+	}
+	defer f.Close()
+
+	bw := bufio.NewWriter(f)
+	err = ws.turtle.writeSvg(bw, crop)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	err = bw.Flush()
+	if err != nil {
+		return errorResult(err)
+	}
+
+	return nil
+}
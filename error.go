@@ -1,22 +1,55 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 )
 
+type LogoError struct {
+	code      int
+	line      int
+	col       int
+	message   string
+	procedure string
+}
+
+func (this *LogoError) Error() string {
+	if this.hasPosition() {
+		return fmt.Sprintf("(%d,%d): %s", this.line, this.col, this.message)
+	}
+	return this.message
+}
+
+func (this *LogoError) hasPosition() bool {
+	return this.line >= 0 && this.col >= 0
+}
+
 func toError(code int, node Node, message string) error {
 	if node != nil {
 		l, c := node.position()
-		if l >= 0 && c >= 0 {
-			return errors.New(fmt.Sprintf("(%d,%d): %s", l, c, message))
-		}
+		return &LogoError{code, l, c, message, ""}
+	}
+	return &LogoError{code, -1, -1, message, ""}
+}
+
+func toLogoError(err error) *LogoError {
+	le, ok := err.(*LogoError)
+	if ok {
+		return le
 	}
-	return errors.New(message)
+	return &LogoError{0, -1, -1, err.Error(), ""}
 }
 
-func userError(message string) error {
-	return errors.New(message)
+type ThrowError struct {
+	tag   string
+	value Node
+}
+
+func (this *ThrowError) Error() string {
+	return "Can't find catch tag for " + this.tag + "."
+}
+
+func throwError(tag string, value Node) error {
+	return &ThrowError{tag, value}
 }
 
 func errorKeywordExpected(node Node, keyword string) error {
@@ -127,3 +160,51 @@ func errorLabelNotFound(node Node, name string) error {
 func errorProcIsBuiltIn(node Node, name string) error {
 	return toError(27, node, "Procedure "+name+"is built in.")
 }
+
+func errorStreamNotFile(node Node, name string) error {
+	return toError(28, node, name+" is not an open file.")
+}
+
+func errorAlreadyDribbling() error {
+	return toError(29, nil, "Already dribbling.")
+}
+
+func errorThrown(node Node, message string) error {
+	return toError(30, node, message)
+}
+
+func errorTooManyInputs(node Node, name string) error {
+	return toError(31, node, "Too many inputs to "+name+".")
+}
+
+func errorNoOutput(caller *WordNode) error {
+	return toError(32, caller, "Template didn't output to "+caller.value+".")
+}
+
+func errorTooManyLevels(node Node, name string) error {
+	return toError(33, node, "Too many levels of recursion in "+name+".")
+}
+
+func errorArrayExpected(node Node) error {
+	return toError(34, node, "Array expected.")
+}
+
+func errorMacroOutput(caller *WordNode) error {
+	return toError(35, caller, "Macro "+caller.value+" didn't output a list.")
+}
+
+func errorNotPaused(caller *WordNode) error {
+	return toError(36, caller, caller.value+" can only be used while paused.")
+}
+
+func errorNoProfile(caller *WordNode) error {
+	return toError(37, caller, "No profile has been taken, use PROFILE first.")
+}
+
+func errorOutsideSandbox(path string) error {
+	return toError(38, nil, path+" is outside the sandbox directory.")
+}
+
+func errorSandboxLimit(node Node, limit string) error {
+	return toError(39, node, "Sandbox "+limit+" limit exceeded.")
+}
@@ -3,6 +3,8 @@ package main
 import (
 	//	"fmt"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 var infixOps []string = []string{"+", "-", "*", "/", "(", ")", "=", "<>", "<", ">", "<=", ">=", "OR", "AND"}
@@ -45,16 +47,39 @@ func callProcedure(frame Frame, node Node, withInfix bool) (*CallResult, Node) {
 		if proc == nil {
 			return errorResult(errorProcedureNotFound(node, wn.value)), nil
 		}
-		if proc.parameterCount() > 0 {
+		varParams := proc.allowVarParameters() && wn.isFirstOfGroup
+		if proc.parameterCount() > 0 || varParams {
 			paramCount := proc.parameterCount()
-			if proc.allowVarParameters() && wn.isFirstOfGroup {
+			if varParams {
 				paramCount = -1
 			}
-			//fmt.Printf("Fetching %d parameters for %s\n", paramCount, wn.value)
-			parameters, node, err = fetchParameters(frame, wn, procName, node.next(), paramCount, withInfix)
+
+			// The input to OUTPUT is in tail position wherever OUTPUT is
+			// reached, so it is fetched as if it were the last statement.
+			bp, isBuiltIn := proc.(*BuiltInProcedure)
+			outputTail := isBuiltIn && bp.name == keywordOutput && isTailTransparent(frame)
+			if outputTail {
+				tc := frame.tailInfo()
+				saved := *tc
+				tc.tail = true
+				tc.argDepth = 0
+				parameters, node, err = fetchParameters(frame, wn, procName, node.next(), paramCount, withInfix)
+				*tc = saved
+			} else {
+				enterArgs(frame)
+				//fmt.Printf("Fetching %d parameters for %s\n", paramCount, wn.value)
+				parameters, node, err = fetchParameters(frame, wn, procName, node.next(), paramCount, withInfix)
+				leaveArgs(frame)
+			}
 			if err != nil {
 				return errorResult(err), nil
 			}
+			if isBuiltIn && len(parameters) == 0 && proc.parameterCount() > 0 {
+				return errorResult(errorNotEnoughParameters(wn, wn)), nil
+			}
+			if outputTail && isFrameStopped(frame) {
+				return stopResult(), nil
+			}
 		} else {
 			parameters = make([]Node, 0, 0)
 			node = node.next()
@@ -73,6 +98,13 @@ func callProcedure(frame Frame, node Node, withInfix bool) (*CallResult, Node) {
 		}
 	}
 
+	if intFrame != nil && len(intFrame.procedure.breaks) > 0 {
+		br := intFrame.checkBreak(frame, wn)
+		if br != nil {
+			return br, nil
+		}
+	}
+
 	if procName == keywordEdit && node != nil {
 		parameters, node, err = fetchParameters(frame, wn, procName, node, 1, withInfix)
 		if err != nil {
@@ -80,6 +112,15 @@ func callProcedure(frame Frame, node Node, withInfix bool) (*CallResult, Node) {
 		}
 	}
 
+	if isContinue(proc) && len(parameters) == 0 && node != nil {
+		if line, _ := node.position(); line == wn.line {
+			parameters, node, err = fetchParameters(frame, wn, procName, node, 1, withInfix)
+			if err != nil {
+				return errorResult(err), nil
+			}
+		}
+	}
+
 	if procName == keywordGo {
 		ln, err := findLabel(frame, node, parameters[0])
 		if err != nil {
@@ -88,12 +129,29 @@ func callProcedure(frame Frame, node Node, withInfix bool) (*CallResult, Node) {
 		return nil, ln
 	}
 
+	ip, isInterpreted := proc.(*InterpretedProcedure)
+	if isInterpreted {
+		tf := tailCallFrame(frame, node)
+		if tf != nil && !ip.macro {
+			tf.setTailCall(ip, wn, parameters)
+			return stopResult(), nil
+		}
+		if frame.depth() >= frame.workspace().maxDepth {
+			return errorResult(errorTooManyLevels(wn, ip.name)), nil
+		}
+	}
+
 	subFrame := proc.createFrame(frame, wn)
 	frame.workspace().currentFrame = subFrame
 	defer func() {
 		frame.workspace().currentFrame = frame
 	}()
 
+	bf, isBuiltIn := subFrame.(*BuiltInFrame)
+	if isBuiltIn && tailTransparent[bf.name] && tailCallFrame(frame, node) != nil {
+		bf.tc.tail = true
+	}
+
 	rv := subFrame.eval(parameters)
 
 	if rv != nil {
@@ -101,6 +159,9 @@ func callProcedure(frame Frame, node Node, withInfix bool) (*CallResult, Node) {
 			return rv, nil
 		}
 	}
+	if isInterpreted && ip.macro {
+		rv = expandMacro(frame, wn, rv)
+	}
 	return rv, node
 }
 
@@ -114,6 +175,11 @@ func callProcedureWithParams(frame Frame, wn *WordNode, parameters ...Node) *Cal
 		return errorResult(errorProcedureNotFound(wn, wn.value))
 	}
 
+	ip, isInterpreted := proc.(*InterpretedProcedure)
+	if isInterpreted && frame.depth() >= frame.workspace().maxDepth {
+		return errorResult(errorTooManyLevels(wn, procName))
+	}
+
 	subFrame := proc.createFrame(frame, wn)
 	frame.workspace().currentFrame = subFrame
 	defer func() {
@@ -127,6 +193,9 @@ func callProcedureWithParams(frame Frame, wn *WordNode, parameters ...Node) *Cal
 			return rv
 		}
 	}
+	if isInterpreted && ip.macro {
+		rv = expandMacro(frame, wn, rv)
+	}
 	return rv
 }
 
@@ -142,7 +211,7 @@ func fetchParameters(frame Frame, caller *WordNode, procName string, firstNode N
 	n := firstNode
 	var rv *CallResult
 	ix := 0
-	for {
+	for n != nil {
 		//fmt.Printf("Evaluating %s\n", n.String())
 		if n.nodeType() == List {
 			params = append(params, n)
@@ -169,6 +238,9 @@ func fetchParameters(frame Frame, caller *WordNode, procName string, firstNode N
 	}
 
 	if ix < paramCount {
+		if firstNode == nil {
+			return nil, nil, errorNotEnoughParameters(caller, caller)
+		}
 		return nil, nil, errorNotEnoughParameters(caller, firstNode)
 	}
 
@@ -180,6 +252,53 @@ func fetchParameters(frame Frame, caller *WordNode, procName string, firstNode N
 	return params, n, nil
 }
 
+// typedWord gives a word that was not read by the parser the meaning it would
+// have had if it had been typed: a word starting with a quote is the quoted
+// word, a number is itself and any other word is a call.
+func typedWord(wn *WordNode) *WordNode {
+
+	if !wn.isLiteral || wn.isQuoted || len(wn.value) == 0 {
+		return wn
+	}
+	c, size := utf8.DecodeRuneInString(wn.value)
+	if c == literalStart && len(wn.value) > size {
+		n := newWordNode(wn.line, wn.col, wn.value[size:], true)
+		n.isQuoted = true
+		return n
+	}
+	if unicode.IsDigit(c) || c == '-' {
+		return wn
+	}
+	n := wn.clone().(*WordNode)
+	n.isLiteral = false
+	return n
+}
+
+// typedList is the list that runs when ln is run, with the words put in it
+// by primitives read as if typed. The list itself is left as it is, and is
+// returned when nothing in it needs to change.
+func typedList(ln *ListNode) *ListNode {
+
+	var items []Node
+	ix := 0
+	for n := ln.firstChild; n != nil; n, ix = n.next(), ix+1 {
+		item := n
+		if wn, ok := n.(*WordNode); ok {
+			item = typedWord(wn)
+		}
+		if item != n && items == nil {
+			items = ln.index()[:ix:ix]
+		}
+		if items != nil {
+			items = append(items, item)
+		}
+	}
+	if items == nil {
+		return ln
+	}
+	return nodesToList(items)
+}
+
 func evalInstructionList(frame Frame, node Node, canReturn bool) *CallResult {
 
 	if node.nodeType() == Word {
@@ -194,10 +313,14 @@ func evalInstructionList(frame Frame, node Node, canReturn bool) *CallResult {
 	}
 
 	switch ln := node.(type) {
-	case *WordNode:
+	case *WordNode, *ArrayNode:
 		return errorResult(errorListExpected(node))
 	case *ListNode:
-		return evalNodeStream(frame, ln.firstChild, canReturn)
+		b := frame.workspace().compiledList(ln)
+		if b != nil {
+			return b.run(frame, canReturn)
+		}
+		return evalNodeStream(frame, typedList(ln).firstChild, canReturn)
 	case *GroupNode:
 		return evalNodeStream(frame, ln.firstChild, canReturn)
 	}
@@ -234,7 +357,9 @@ func evaluateExpression(frame Frame, n Node) (*CallResult, Node) {
 				exit = true
 			} else {
 				var rv *CallResult
+				enterArgs(frame)
 				rv = evalNodeStream(frame, nn.firstChild, true)
+				leaveArgs(frame)
 				if rv != nil {
 					if rv.shouldStop() {
 						return rv, nil
@@ -251,16 +376,18 @@ func evaluateExpression(frame Frame, n Node) (*CallResult, Node) {
 				if nn.value == "-" && (prevIx == -2 || (prevIx >= 0 && prevIx != 5)) && nn.next() != nil {
 					// Looks like a unary minus
 					var rv *CallResult
+					enterArgs(frame)
 					rv, n = evaluateNode(frame, nn.next(), true)
+					leaveArgs(frame)
 					if rv.shouldStop() {
 						return rv, nil
 					}
-					v, err := evalToNumber(rv.returnValue)
+					v, err := evalToNumeric(rv.returnValue)
 					if err != nil {
 						return errorResult(err), nil
 					}
 
-					nwn := createNumericNode(0.0 - v).(*WordNode)
+					nwn := createNumberNode(negateNumeric(v)).(*WordNode)
 					nl = append(nl, nwn)
 					expectOp = true
 				} else {
@@ -305,7 +432,14 @@ func evaluateExpression(frame Frame, n Node) (*CallResult, Node) {
 				} else if !nn.isLiteral {
 					//fmt.Printf("Lets call a proc!\n")
 					var rv *CallResult
+					nested := len(nl) > 0 || len(ops) > 0
+					if nested {
+						enterArgs(frame)
+					}
 					rv, n = callProcedure(frame, nn, true)
+					if nested {
+						leaveArgs(frame)
+					}
 					if rv != nil {
 						if rv.shouldStop() {
 							return rv, nil
@@ -315,13 +449,24 @@ func evaluateExpression(frame Frame, n Node) (*CallResult, Node) {
 							expectOp = true
 						}
 					}
+					if !expectOp && isFrameStopped(frame) {
+						return stopResult(), nil
+					}
 				} else {
-					nl = append(nl, nn)
+					nl = append(nl, nn.datum())
 					n = n.next()
 					expectOp = true
 				}
 			}
 			prevIx = ix
+		case *ArrayNode:
+			if expectOp {
+				exit = true
+			} else {
+				nl = append(nl, nn)
+				n = n.next()
+				expectOp = true
+			}
 		case *ListNode:
 			exit = true
 			break
@@ -354,10 +499,7 @@ func evaluateExpression(frame Frame, n Node) (*CallResult, Node) {
 
 	var rv *CallResult
 	if len(nl) == 1 {
-		rv, _ = evaluateNode(frame, nl[0], false)
-		if rv.shouldStop() {
-			return rv, nil
-		}
+		rv = returnResult(nl[0])
 	}
 	return rv, n
 }
@@ -376,13 +518,15 @@ func evaluateNode(frame Frame, node Node, withInfix bool) (*CallResult, Node) {
 				}
 				return rv, node
 			} else {
-				return returnResult(nn), nn.next()
+				return returnResult(nn.datum()), nn.next()
 			}
 		}
 	case *GroupNode:
+		enterArgs(frame)
+		defer leaveArgs(frame)
 		return evalNodeStream(frame, nn.firstChild, true), node.next()
 
-	case *ListNode:
+	case *ListNode, *ArrayNode:
 		return returnResult(nn), node.next()
 	}
 
@@ -397,7 +541,7 @@ func evalNodeStream(frame Frame, node Node, canReturnValue bool) *CallResult {
 	var rv *CallResult = nil
 	for node != nil {
 		switch n := node.(type) {
-		case *ListNode:
+		case *ListNode, *ArrayNode:
 			if canReturnValue {
 				lastValue = n
 				node = node.next()
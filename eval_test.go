@@ -1,13 +1,32 @@
 package main
 
 import (
+	"os"
+	"path"
 	"testing"
+	"time"
 )
 
 var ws *Workspace = CreateWorkspace()
 
 func assertExpression(t *testing.T, expr, expectedVal string) {
 
+	assertExpressionAs(t, "compiled", expr, expectedVal)
+
+	compileCode = false
+	defer func() { compileCode = true }()
+	assertExpressionAs(t, "interpreted", expr, expectedVal)
+}
+
+// assertExpressionOnce is for expressions that can't be repeated, such as
+// those that read from a file.
+func assertExpressionOnce(t *testing.T, expr, expectedVal string) {
+
+	assertExpressionAs(t, "compiled", expr, expectedVal)
+}
+
+func assertExpressionAs(t *testing.T, mode, expr, expectedVal string) {
+
 	n, err := ParseString(expr)
 	if err != nil {
 		t.Error(err)
@@ -16,15 +35,15 @@ func assertExpression(t *testing.T, expr, expectedVal string) {
 	cr, _ := evaluateExpression(ws.currentFrame, n)
 
 	if cr.err != nil {
-		t.Error(cr.err)
+		t.Errorf("%s %s: %s", mode, expr, cr.err)
 	}
 
 	if cr.returnValue == nil {
-		t.Error("No return value.")
+		t.Fatalf("%s %s: No return value.", mode, expr)
 	}
 
 	if cr.returnValue.String() != expectedVal {
-		t.Errorf("Expected \"%s\" was \"%s\"", expectedVal, cr.returnValue.String())
+		t.Errorf("%s %s: Expected \"%s\" was \"%s\"", mode, expr, expectedVal, cr.returnValue.String())
 	}
 }
 
@@ -103,3 +122,377 @@ func TestSetVariableWithParens(t *testing.T) {
 func TestSetVariableWithParensAndDiv(t *testing.T) {
 	assertExpression(t, "make \"s ( 10 - 1 ) / 800 :s", "0.01125")
 }
+
+func TestCatchError(t *testing.T) {
+
+	err := ws.readString("CATCH \"ERROR [SUM 1 \"x]")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertExpressionOnce(t, "FIRST ERROR", "14")
+	assertExpression(t, "EMPTYP ERROR", "TRUE")
+}
+
+func TestCatchThrowValue(t *testing.T) {
+
+	assertExpression(t, "CATCH \"done [(THROW \"done 42) 1]", "42")
+	assertExpression(t, "CATCH \"outer [CATCH \"ERROR [(THROW \"outer \"x)] \"y]", "x")
+}
+
+func TestTemplates(t *testing.T) {
+
+	assertExpression(t, "MAP [? * 2] [1 2 3]", "[ 2 4 6 ]")
+	assertExpression(t, "(MAP [?1 + ?2] [1 2] [10 20])", "[ 11 22 ]")
+	assertExpression(t, "MAP [#] [a b c]", "[ 1 2 3 ]")
+	assertExpression(t, "MAP [COUNT ?REST] [a b c]", "[ 2 1 0 ]")
+	assertExpression(t, "MAP [[x] :x + 1] [1 2]", "[ 2 3 ]")
+	assertExpression(t, "FILTER [? > 1] [1 2 3]", "[ 2 3 ]")
+	assertExpression(t, "FIND [? > 1] [1 2 3]", "2")
+	assertExpression(t, "REDUCE \"SUM [1 2 3 4]", "10")
+	assertExpression(t, "APPLY \"SUM [1 2]", "3")
+	assertExpression(t, "(INVOKE [?1 - ?2] 5 3)", "2")
+	assertExpression(t, "CROSSMAP [WORD ?1 ?2] [[a b] [1 2]]", "[ a1 a2 b1 b2 ]")
+}
+
+func TestLoops(t *testing.T) {
+
+	assertExpression(t, "make \"t 0 FOR [i 1 5] [make \"t :t + :i] :t", "15")
+	assertExpression(t, "make \"t 0 FOR [i 10 0 -5] [make \"t :t + :i] :t", "15")
+	assertExpression(t, "make \"t 0 WHILE [:t < 3] [make \"t :t + 1] :t", "3")
+	assertExpression(t, "make \"t 0 DO.UNTIL [make \"t :t + 1] [:t > 0] :t", "1")
+	assertExpression(t, "make \"t 0 REPEAT 4 [make \"t :t + REPCOUNT] :t", "10")
+}
+
+func TestTailCall(t *testing.T) {
+
+	err := ws.readString("TO TAILSUM :n :acc\nIF :n = 0 [OUTPUT :acc]\nOUTPUT TAILSUM :n - 1 :acc + :n\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ws.readString("TO TAILCOUNT :n\nIF :n = 0 [STOP]\nMAKE \"tailcount :n\nTAILCOUNT :n - 1\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertExpression(t, "TAILSUM 1000 0", "500500")
+	assertExpression(t, "TAILCOUNT 10000 :tailcount", "1")
+
+	err = ws.readString("TO TAILOUTER :tly\nOUTPUT TAILINNER\nEND\n" +
+		"TO TAILINNER\nOUTPUT :tly + 1\nEND\n" +
+		"TO TAILSTMT :tly\nLOCAL \"tlz\nMAKE \"tlz 3\nTAILSET\nEND\n" +
+		"TO TAILSET\nMAKE \"tailseen :tly + :tlz\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExpression(t, "TAILOUTER 5", "6")
+	assertExpression(t, "TAILSTMT 4 :tailseen", "7")
+}
+
+func TestRecursionLimit(t *testing.T) {
+
+	err := ws.readString("TO RUNAWAY :n\nOUTPUT 1 + RUNAWAY :n + 1\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ws.readString("SETRECURSIONLIMIT 100 CATCH \"ERROR [PRINT RUNAWAY 1]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.readString("SETRECURSIONLIMIT 10000")
+
+	assertExpressionOnce(t, "FIRST ERROR", "33")
+}
+
+func TestCompiledRedefinition(t *testing.T) {
+
+	err := ws.readString("TO COMPILEDINNER :x\nOUTPUT :x * 2\nEND\nTO COMPILEDOUTER\nOUTPUT COMPILEDINNER 3\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExpression(t, "COMPILEDOUTER", "6")
+
+	err = ws.readString("TO COMPILEDINNER :x\nOUTPUT :x + 1\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExpression(t, "COMPILEDOUTER", "4")
+}
+
+func TestCompiledGo(t *testing.T) {
+
+	err := ws.readString("TO COMPILEDGO :n\nMAKE \"i 0\nLABEL \"top\nMAKE \"i :i + 1\nIF :i < :n [GO \"top]\nOUTPUT :i\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExpression(t, "COMPILEDGO 5", "5")
+}
+
+func TestSharedLists(t *testing.T) {
+
+	err := ws.readString("MAKE \"shared [b c d]\nMAKE \"sharedfput FPUT \"a :shared\nMAKE \"sharedbf BUTFIRST :sharedfput")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertExpression(t, ":sharedfput", "[ a b c d ]")
+	assertExpression(t, ":shared", "[ b c d ]")
+	assertExpression(t, "COUNT :sharedfput", "4")
+	assertExpression(t, "ITEM 3 :sharedbf", "d")
+	assertExpression(t, "LPUT \"e :shared", "[ b c d e ]")
+	assertExpression(t, "BUTLAST :sharedfput", "[ a b c ]")
+	assertExpression(t, "LAST :sharedbf", "d")
+	assertExpression(t, ":shared", "[ b c d ]")
+	assertExpression(t, "BUTLAST [a]", "[ ]")
+	assertExpression(t, "(LIST 1 2 3)", "[ 1 2 3 ]")
+}
+
+func TestArrays(t *testing.T) {
+
+	err := ws.readString("MAKE \"arr {a b c}@0\nMAKE \"arrcopy :arr\nSETITEM 1 :arrcopy \"x\nMAKE \"md MDARRAY [2 2]\nMDSETITEM [2 1] :md 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertExpression(t, ":arr", "{ a x c }@0")
+	assertExpression(t, "ITEM 0 :arr", "a")
+	assertExpression(t, "COUNT :arr", "3")
+	assertExpression(t, "ARRAYTOLIST :arr", "[ a x c ]")
+	assertExpression(t, "ARRAYP LISTTOARRAY [1 2]", "TRUE")
+	assertExpression(t, "MDITEM [2 1] :md", "5")
+	assertExpression(t, ":md", "{ { [ ] [ ] } { 5 [ ] } }")
+	assertExpression(t, "FIRST :arr", "0")
+	assertExpression(t, "FIRST {a b}", "1")
+	assertExpression(t, "(LIST FIRST {a b} 1)", "[ 1 1 ]")
+	for _, op := range []string{"LAST", "BUTFIRST", "BUTLAST"} {
+		assertExpression(t, "CATCH \"ERROR ["+op+" {a b}] FIRST ERROR", "14")
+	}
+}
+
+func TestExactArithmetic(t *testing.T) {
+
+	assertExpression(t, "PRODUCT 99999999 99999999", "9999999800000001")
+	assertExpression(t, "9223372036854775807 + 1", "9223372036854775808")
+	assertExpression(t, "(PRODUCT 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16 17 18 19 20 21 22 23 24 25)", "15511210043330985984000000")
+	assertExpression(t, "POW 2 100", "1267650600228229401496703205376")
+	assertExpression(t, "DIFFERENCE -1 POW 2 64", "-18446744073709551617")
+	assertExpression(t, "QUOTIENT POW 10 30 POW 10 28", "100")
+	assertExpression(t, "7 / 2", "3.5")
+	assertExpression(t, "INTQUOTIENT POW 10 20 3", "33333333333333333333")
+	assertExpression(t, "REMAINDER POW 10 20 7", "2")
+	assertExpression(t, "1e20 + 0", "1e+20")
+	assertExpression(t, "1e300 * 1", "1e+300")
+	assertExpression(t, "PRODUCT SQRT 2 1e20", "1.4142135623730951e+20")
+	assertExpression(t, "2.5 * 2", "5")
+	assertExpression(t, "(2.5 * 2) + POW 2 60", "1.152921504606847e+18")
+	assertExpression(t, "INT 7.5", "7")
+	assertExpression(t, "(INT 7.5) + POW 2 60", "1152921504606846983")
+	assertExpression(t, "GREATERP POW 2 70 DIFFERENCE POW 2 70 1", "TRUE")
+	assertExpression(t, "EQUALP POW 2 70 1180591620717411303424", "TRUE")
+	assertExpression(t, "(MAXIMUM 3 POW 2 70 5)", "1180591620717411303424")
+}
+
+func TestDefine(t *testing.T) {
+
+	err := ws.readString("DEFINE \"DEFSQUARE [[x] [OUTPUT :x * :x]]\nCOPYDEF \"DEFSQ \"DEFSQUARE\nTO DEFTWO :a :b\nMAKE \"c :a + :b\nOUTPUT (LIST \"c :c)\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertExpression(t, "DEFSQUARE 7", "49")
+	assertExpression(t, "DEFSQ 3", "9")
+	assertExpression(t, "PROCTEXT \"DEFTWO", "[ [ a b ] [ MAKE c :a + :b ] [ OUTPUT ( LIST c :c ) ] ]")
+	assertExpression(t, "DEFINE \"DEFCOPY PROCTEXT \"DEFTWO DEFCOPY 1 2", "[ c 3 ]")
+	assertExpression(t, "DEFINEDP \"DEFSQ", "TRUE")
+	assertExpression(t, "PRIMITIVEP \"DEFSQ", "FALSE")
+	assertExpression(t, "PRIMITIVEP \"SUM", "TRUE")
+	assertExpression(t, "ARITY \"DEFTWO", "[ 2 2 2 ]")
+	assertExpression(t, "ARITY \"SUM", "[ 2 2 -1 ]")
+	assertExpression(t, "MEMBERP \"DEFSQ PROCEDURES", "TRUE")
+	assertExpression(t, "MEMBERP \"FPUT PRIMITIVES", "TRUE")
+}
+
+func TestOptionalInputs(t *testing.T) {
+
+	err := ws.readString("TO OPTFOO :a [:b :a + 10] [:rest]\nOUTPUT (LIST :a :b :rest)\nEND\nTO OPTBAR [:x 5] 1\nOUTPUT :x * 2\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertExpression(t, "OPTFOO 1", "[ 1 11 [ ] ]")
+	assertExpression(t, "(OPTFOO 1 2)", "[ 1 2 [ ] ]")
+	assertExpression(t, "(OPTFOO 1 2 3 4)", "[ 1 2 [ 3 4 ] ]")
+	assertExpression(t, "OPTBAR 3", "6")
+	assertExpression(t, "(OPTBAR)", "10")
+	assertExpression(t, "ARITY \"OPTFOO", "[ 1 1 -1 ]")
+	assertExpression(t, "ARITY \"OPTBAR", "[ 0 1 1 ]")
+	assertExpression(t, "FIRST PROCTEXT \"OPTFOO", "[ a [ b :a + 10 ] [ rest ] ]")
+	assertExpression(t, "CATCH \"ERROR [(OPTBAR 1 2)] FIRST ERROR", "31")
+}
+
+func TestMacros(t *testing.T) {
+
+	err := ws.readString(".MACRO MACWHILE :cond :body\nIF NOT RUN :cond [OUTPUT []]\nOUTPUT (LIST \"RUN :body \"MACWHILE :cond :body)\nEND\n.DEFMACRO \"MACPLUS [[a b] [OUTPUT (LIST \"SUM :a :b)]]\n" +
+		".MACRO MACQUOTE\nOUTPUT [WORD \"hel \"lo]\nEND\n" +
+		".MACRO MACMAKE :n\nOUTPUT (LIST \"MAKE \"\"macz :n)\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertExpression(t, "MAKE \"macn 0 MACWHILE [:macn < 5] [MAKE \"macn :macn + 1] :macn", "5")
+	assertExpression(t, "MACPLUS 2 3", "5")
+	assertExpression(t, "MACROP \"MACPLUS", "TRUE")
+	assertExpression(t, "MACROP \"SUM", "FALSE")
+	assertExpression(t, "MACQUOTE", "hello")
+	assertExpression(t, "MACMAKE 7 :macz", "7")
+}
+
+func TestRunBuiltList(t *testing.T) {
+
+	assertExpression(t, "COUNT \"\"z", "2")
+	assertExpression(t, "RUN (LIST \"WORD \"\"a \"\"b)", "ab")
+	assertExpression(t, "RUN FPUT \"SUM [2 3]", "5")
+	assertExpression(t, "RUN LPUT \"\"c [WORD \"b]", "bc")
+	assertExpression(t, "RUN (SENTENCE \"WORD [\"a \"b])", "ab")
+	assertExpression(t, "RUN (SENTENCE [WORD \"a] \"\"b)", "ab")
+	assertExpression(t, "FIRST FPUT \"\"q []", "\"q")
+	assertExpression(t, "EQUALP FIRST FPUT \"\"q [] \"\"q", "TRUE")
+	assertExpression(t, "COUNT ITEM 2 (LIST \"a \"\"b)", "2")
+	assertExpression(t, "MEMBERP \"\"q (LIST \"\"q)", "TRUE")
+	assertExpression(t, "CATCH \"ERROR [RUN LIST \"PRINT \"RUNLISTNOTDEFINED] FIRST ERROR", "4")
+}
+
+func TestContinueOutsidePause(t *testing.T) {
+
+	assertExpression(t, "CATCH \"ERROR [CO] FIRST ERROR", "36")
+}
+
+func TestBreakpoints(t *testing.T) {
+
+	err := ws.readString("TO BRKFOO :x\nOUTPUT :x + 1\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertExpression(t, "CATCH \"ERROR [BREAK \"SUM 2] FIRST ERROR", "27")
+	assertExpression(t, "BREAK \"BRKFOO 5 UNBREAK \"BRKFOO BRKFOO 1", "2")
+}
+
+func TestTrace(t *testing.T) {
+
+	err := ws.readString("TO TRFOO :x\nOUTPUT :x * 2\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertExpression(t, "TRACE \"TRFOO UNTRACE \"TRFOO TRFOO 2", "4")
+	assertExpression(t, "TRACE [[TRFOO] [TRX]] MAKE \"TRX TRFOO 3 UNTRACE [[TRFOO] [TRX]] :TRX", "6")
+	assertExpression(t, "CATCH \"ERROR [TRACE [TRFOO [TRX]]] FIRST ERROR", "2")
+	if ws.isTraced("TRFOO") || ws.tracedVars["TRX"] {
+		t.Error("UNTRACE did not remove TRFOO and TRX")
+	}
+}
+
+func TestProfile(t *testing.T) {
+
+	err := ws.readString("TO PRFOO :x\nOUTPUT :x + 1\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws.profile = nil
+	assertExpression(t, "CATCH \"ERROR [PROFILEREPORT] FIRST ERROR", "37")
+	assertExpression(t, "PROFILE MAKE \"prx PRFOO PRFOO 1 NOPROFILE :prx", "3")
+	if e := ws.profile.entries["PRFOO"]; e == nil || e.calls != 2 {
+		t.Error("PROFILE did not count the calls to PRFOO")
+	}
+	if e := ws.profile.entries["SUM"]; e == nil || e.calls != 2 {
+		t.Error("PROFILE did not count the calls to SUM")
+	}
+}
+
+func TestSandbox(t *testing.T) {
+
+	ws.SetSandbox(Sandbox{MaxInstructions: 1000})
+	err := ws.readString("FOREVER [MAKE \"sbx 1]")
+	ws.sandbox = nil
+	le, ok := err.(*LogoError)
+	if !ok || le.code != 39 {
+		t.Errorf("Expected the instruction limit to stop FOREVER, was %v", err)
+	}
+
+	for _, loop := range []string{"FOREVER []", "REPEAT 1000000000 []", "WHILE [\"TRUE] []"} {
+		ws.SetSandbox(Sandbox{MaxInstructions: 1000})
+		err = ws.readString(loop)
+		ws.sandbox = nil
+		if le, ok := err.(*LogoError); !ok || le.code != 39 {
+			t.Errorf("Expected the instruction limit to stop %s, was %v", loop, err)
+		}
+	}
+
+	ws.SetSandbox(Sandbox{MaxTime: time.Second})
+	start := time.Now()
+	err = ws.readString("WAIT 60")
+	ws.sandbox = nil
+	if le, ok := err.(*LogoError); !ok || le.code != 39 || time.Since(start) > time.Second {
+		t.Errorf("Expected the time limit to stop WAIT, was %v", err)
+	}
+
+	for _, big := range []string{"MAKE \"sbw \"a REPEAT 10 [MAKE \"sbw WORD :sbw :sbw]", "MAKE \"sbl ARRAY 1000", "MAKE \"sbl MDARRAY [20 20]"} {
+		ws.SetSandbox(Sandbox{MaxValueSize: 100})
+		err = ws.readString(big)
+		ws.sandbox = nil
+		if le, ok := err.(*LogoError); !ok || le.code != 39 {
+			t.Errorf("Expected the value size limit to stop %s, was %v", big, err)
+		}
+	}
+
+	f := &Files{rootPath: "/home/logo", jail: "/home/logo"}
+	for _, p := range []string{"a.txt", "sub/../b.txt", "/home/logo/c.txt"} {
+		if _, err := f.normPath(p); err != nil {
+			t.Errorf("%s should be allowed: %v", p, err)
+		}
+	}
+	for _, p := range []string{"../a.txt", "/etc/passwd", "/home/logo2/a.txt"} {
+		if _, err := f.normPath(p); err == nil {
+			t.Errorf("%s should be outside the sandbox", p)
+		}
+	}
+
+	prefix, outside := t.TempDir(), t.TempDir()
+	os.Mkdir(path.Join(prefix, "sub"), 0755)
+	for link, target := range map[string]string{"out": outside, "sub/up": "..", "new.txt": path.Join(outside, "new.txt")} {
+		err = os.Symlink(target, path.Join(prefix, link))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	jail, err := resolvePath(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = &Files{rootPath: prefix, jail: jail}
+	for _, p := range []string{"a.txt", "sub/up/a.txt", "sub/b.txt"} {
+		if _, err := f.normPath(p); err != nil {
+			t.Errorf("%s should be allowed: %v", p, err)
+		}
+	}
+	for _, p := range []string{"out", "out/a.txt", "sub/up/out/a.txt", "new.txt"} {
+		if _, err := f.normPath(p); err == nil {
+			t.Errorf("%s should be outside the sandbox through a link", p)
+		}
+	}
+}
+
+func TestOutputFirst(t *testing.T) {
+
+	err := ws.readString("TO OUTFIRST :x\nOUTPUT FIRST :x\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertExpression(t, "OUTFIRST [a b]", "a")
+	assertExpression(t, "OUTFIRST \"abc", "a")
+	assertExpression(t, "OUTFIRST [[a b] c]", "[ a b ]")
+}
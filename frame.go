@@ -75,7 +75,16 @@ func (this *VarList) setVariable(frame Frame, name string, value Node) {
 
 	uname := strings.ToUpper(name)
 	v := this.getVariableInner(frame, name, uname, true)
+	old := v.value
 	v.value = value
+
+	ws := frame.workspace()
+	if len(ws.watches) > 0 && ws.watches[uname] {
+		watchHit(frame, v, old)
+	}
+	if len(ws.tracedVars) > 0 && ws.tracedVars[uname] {
+		traceMake(frame, v)
+	}
 }
 
 func (this *VarList) getVariable(frame Frame, name string) Node {
@@ -221,6 +230,27 @@ type Frame interface {
 	getTestValue() Node
 	getVars() *VarList
 	isStepped() bool
+	tailInfo() *tailContext
+}
+
+// tailContext tracks whether a call made at the statement level of a
+// frame is in tail position of the enclosing interpreted procedure.
+type tailContext struct {
+	tail     bool
+	argDepth int
+}
+
+type tailCall struct {
+	procedure  *InterpretedProcedure
+	caller     *WordNode
+	parameters []Node
+}
+
+var tailTransparent = map[string]bool{
+	"IF":      true,
+	"IFTRUE":  true,
+	"IFFALSE": true,
+	"RUN":     true,
 }
 
 type Procedure interface {
@@ -243,7 +273,7 @@ func (this *BuiltInProcedure) parameterCount() int {
 }
 
 func (this *BuiltInProcedure) createFrame(parentFrame Frame, caller *WordNode) Frame {
-	return &BuiltInFrame{parentFrame.workspace(), parentFrame, parentFrame.depth() + 1, caller, this.realProc, newVarList(), this.name}
+	return &BuiltInFrame{parentFrame.workspace(), parentFrame, parentFrame.depth() + 1, caller, this.realProc, newVarList(), this.name, 0, tailContext{}}
 }
 
 func (this *BuiltInProcedure) allowVarParameters() bool {
@@ -258,6 +288,8 @@ type BuiltInFrame struct {
 	realProc   evaluator
 	vars       *VarList
 	name       string
+	repCount   int
+	tc         tailContext
 }
 
 func (this *BuiltInFrame) parentFrame() Frame {
@@ -278,7 +310,36 @@ func (this *BuiltInFrame) caller() *WordNode {
 
 func (this *BuiltInFrame) eval(parameters []Node) *CallResult {
 
-	return this.realProc(this, parameters)
+	if this.ws.sandbox != nil {
+		if err := this.ws.sandbox.step(this.callerNode); err != nil {
+			return errorResult(err)
+		}
+		return this.ws.sandbox.output(this.callerNode, this.call(parameters))
+	}
+	return this.call(parameters)
+}
+
+// call runs the primitive, tracing and profiling it when that is on.
+func (this *BuiltInFrame) call(parameters []Node) *CallResult {
+
+	traced := this.ws.isTraced(this.name)
+	prof := this.ws.profiling()
+	if !traced && prof == nil {
+		return this.realProc(this, parameters)
+	}
+
+	if prof != nil {
+		prof.enter(this.name)
+		defer prof.exit()
+	}
+	if traced {
+		traceCall(this.ws, this.d, this.name, parameters)
+	}
+	rv := this.realProc(this, parameters)
+	if traced && (rv == nil || !rv.hasError()) {
+		traceExit(this.ws, this.d, this.name, rv)
+	}
+	return rv
 }
 
 func (this *BuiltInFrame) setTestValue(node Node) {
@@ -294,6 +355,8 @@ func (this *BuiltInFrame) getVars() *VarList {
 
 func (this *BuiltInFrame) isStepped() bool { return false }
 
+func (this *BuiltInFrame) tailInfo() *tailContext { return &this.tc }
+
 type RootFrame struct {
 	ws      *Workspace
 	node    Node
@@ -334,6 +397,8 @@ func (this *RootFrame) getVars() *VarList {
 
 func (this *RootFrame) isStepped() bool { return false }
 
+func (this *RootFrame) tailInfo() *tailContext { return nil }
+
 type InterpretedFrame struct {
 	ws         *Workspace
 	parent     Frame
@@ -345,8 +410,26 @@ type InterpretedFrame struct {
 	vars       *VarList
 	stopped    bool
 	aborted    bool
+	tc         tailContext
+	pending    *tailCall
+	breakLine  int
+	replaced   []replacedCall
+}
+
+// A replacedCall records a call whose frame was reused for a tail call, so
+// BACKTRACE can still show it. Repeated calls from the same place, as in a
+// tail recursive loop, are kept as one record.
+type replacedCall struct {
+	procedure  *InterpretedProcedure
+	caller     *WordNode
+	parameters []Node
+	count      int
 }
 
+// maxReplacedCalls bounds the records kept for a frame, the oldest being
+// dropped first.
+const maxReplacedCalls = 64
+
 func (this *InterpretedFrame) abort() {
 	this.stopped = true
 	this.aborted = true
@@ -370,22 +453,148 @@ func (this *InterpretedFrame) depth() int {
 
 func (this *InterpretedFrame) eval(parameters []Node) *CallResult {
 
-	for px := 0; px < len(parameters); px++ {
-		this.vars.createLocal(this.procedure.parameters[px])
-		this.vars.setVariable(this, this.procedure.parameters[px], parameters[px])
+	// Procedures replaced by tail calls finish along with this frame, so
+	// their exits are traced once it returns.
+	var traced []string
+	prof := this.ws.profiling()
+	if prof != nil {
+		defer prof.exit()
+	}
+	for {
+		if prof != nil {
+			prof.enter(this.procedure.name)
+		}
+		if this.ws.sandbox != nil {
+			if err := this.ws.sandbox.step(this.callerNode); err != nil {
+				return errorResult(err)
+			}
+		}
+		if this.ws.isTraced(this.procedure.name) {
+			traceCall(this.ws, this.d, this.procedure.name, parameters)
+			traced = append(traced, this.procedure.name)
+		}
+
+		rv := this.bindInputs(parameters)
+		if rv != nil {
+			return rv
+		}
+
+		if this.procedure.firstNode != nil {
+			var rv *CallResult
+			b := this.procedure.compiledBody(this.ws)
+			if b != nil {
+				rv = b.run(this, false)
+			} else {
+				rv = evalNodeStream(this, this.procedure.firstNode, false)
+			}
+			if rv != nil && rv.hasError() {
+				le, ok := rv.err.(*LogoError)
+				if ok && le.procedure == "" {
+					le.procedure = this.procedure.name
+				}
+				return errorPause(this, rv)
+			}
+		}
+
+		tc := this.pending
+		if tc == nil || this.aborted {
+			break
+		}
+
+		// Reuse this frame for the call that was made in tail position.
+		// The variables of the procedure being replaced are kept so the
+		// new one can still see them, its inputs and locals shadowing any
+		// with the same names as they would in a new frame.
+		if prof != nil {
+			prof.exit()
+		}
+		this.recordReplaced(parameters)
+		this.procedure = tc.procedure
+		this.callerNode = tc.caller
+		this.returnVal = nil
+		this.testVal = nil
+		this.stopped = false
+		this.pending = nil
+		this.breakLine = 0
+		parameters = tc.parameters
+	}
+
+	var rv *CallResult
+	if this.returnVal != nil {
+		rv = returnResult(this.returnVal)
+	}
+	for ix := len(traced) - 1; ix >= 0; ix-- {
+		traceExit(this.ws, this.d, traced[ix], rv)
+	}
+	return rv
+}
+
+func (this *InterpretedFrame) recordReplaced(parameters []Node) {
+
+	if n := len(this.replaced); n > 0 {
+		last := &this.replaced[n-1]
+		if last.procedure == this.procedure && last.caller == this.callerNode {
+			last.parameters = parameters
+			last.count++
+			return
+		}
 	}
+	if len(this.replaced) == maxReplacedCalls {
+		copy(this.replaced, this.replaced[1:])
+		this.replaced = this.replaced[:maxReplacedCalls-1]
+	}
+	this.replaced = append(this.replaced, replacedCall{this.procedure, this.callerNode, parameters, 1})
+}
 
-	if this.procedure.firstNode != nil {
-		rv := evalNodeStream(this, this.procedure.firstNode, false)
+func (this *InterpretedFrame) setInput(name string, value Node) {
+	this.vars.createLocal(name)
+	this.vars.setVariable(this, name, value)
+}
+
+// bindInputs makes the inputs of the procedure local variables, evaluating
+// the defaults of any optional inputs that were not supplied. Defaults are
+// evaluated in order, so may refer to the inputs before them.
+func (this *InterpretedFrame) bindInputs(parameters []Node) *CallResult {
+
+	p := this.procedure
+	if len(parameters) < len(p.parameters) {
+		return errorResult(errorNotEnoughParameters(this.callerNode, this.callerNode))
+	}
+	if max := p.maxParameters(); max >= 0 && len(parameters) > max {
+		return errorResult(errorTooManyInputs(this.callerNode, p.name))
+	}
+
+	for px, name := range p.parameters {
+		this.setInput(name, parameters[px])
+	}
+	px := len(p.parameters)
+
+	for _, o := range p.optional {
+		if px < len(parameters) {
+			this.setInput(o.name, parameters[px])
+			px++
+			continue
+		}
+		enterArgs(this)
+		rv := evalNodeStream(this, o.list.firstChild.next(), true)
+		leaveArgs(this)
 		if rv != nil && rv.hasError() {
 			return rv
 		}
+		if rv == nil || rv.returnValue == nil {
+			return errorResult(errorBadInput(o.list))
+		}
+		this.setInput(o.name, rv.returnValue)
 	}
 
-	if this.returnVal == nil {
-		return nil
+	if p.rest != "" {
+		if px < len(parameters) {
+			this.setInput(p.rest, nodesToList(parameters[px:]))
+		} else {
+			this.setInput(p.rest, newListNode(-1, -1, nil))
+		}
 	}
-	return returnResult(this.returnVal)
+	return nil
 }
 
 func (this *InterpretedFrame) setReturnValue(returnVal Node) {
@@ -397,6 +606,13 @@ func (this *InterpretedFrame) stop() {
 	this.stopped = true
 }
 
+func (this *InterpretedFrame) setTailCall(proc *InterpretedProcedure, caller *WordNode, parameters []Node) {
+	this.pending = &tailCall{proc, caller, parameters}
+	this.stopped = true
+}
+
+func (this *InterpretedFrame) tailInfo() *tailContext { return &this.tc }
+
 func (this *InterpretedFrame) setTestValue(node Node) {
 	this.testVal = node
 }
@@ -444,25 +660,48 @@ type InterpretedProcedure struct {
 	source     string
 	buried     bool
 	step       bool
+	code       *compiledBlock
+	optional   []optionalInput
+	rest       string
+	arity      int
+	macro      bool
+	firstLine  int
+	breaks     map[int]bool
+}
+
+// An optional input is written [:name default] in the title line, the
+// default being evaluated when the caller does not supply the input.
+type optionalInput struct {
+	name string
+	list *ListNode
 }
 
 func (this *InterpretedProcedure) createFrame(parentFrame Frame, caller *WordNode) Frame {
 
-	parentFrame.workspace().trace(parentFrame.depth(), this.name)
-	return &InterpretedFrame{parentFrame.workspace(), parentFrame, parentFrame.depth() + 1, caller, this, nil, nil, newVarList(), false, false}
+	return &InterpretedFrame{parentFrame.workspace(), parentFrame, parentFrame.depth() + 1, caller, this, nil, nil, newVarList(), false, false, tailContext{true, 0}, nil, 0, nil}
 }
 
 func (this *InterpretedProcedure) parameterCount() int {
-	return len(this.parameters)
+	return this.arity
 }
 
 func (this *InterpretedProcedure) allowVarParameters() bool {
-	return false
+	return len(this.optional) > 0 || this.rest != ""
+}
+
+// maxParameters returns the most inputs the procedure accepts, or -1 if
+// it has a rest input.
+func (this *InterpretedProcedure) maxParameters() int {
+	if this.rest != "" {
+		return -1
+	}
+	return len(this.parameters) + len(this.optional)
 }
 
 func readInterpretedProcedure(node Node) (*InterpretedProcedure, Node, error) {
 
-	if !isWordNodeWithValue(node, keywordTo) {
+	isMacro := isWordNodeWithValue(node, keywordMacro)
+	if !isMacro && !isWordNodeWithValue(node, keywordTo) {
 		return nil, nil, errorKeywordExpected(node, keywordTo)
 	}
 
@@ -471,16 +710,50 @@ func readInterpretedProcedure(node Node) (*InterpretedProcedure, Node, error) {
 		return nil, nil, err
 	}
 
+	titleLine, _ := node.position()
 	params := make([]string, 0, 2)
-	for ; n != nil; n = n.next() {
-		wn := n.(*WordNode)
-		if wn == nil {
+	optional := make([]optionalInput, 0)
+	rest := ""
+	arity := -1
+	for ; n != nil && arity < 0; n = n.next() {
+		l, _ := n.position()
+		if l != titleLine && n.nodeType() != Word {
 			break
 		}
-		if wn.value[0] != ':' {
-			break
+		switch pn := n.(type) {
+		case *WordNode:
+			if _, isNum := pn.number(); isNum && l == titleLine {
+				arity, err = evalToInteger(pn)
+				if err != nil || arity < len(params) || (rest == "" && arity > len(params)+len(optional)) {
+					return nil, nil, errorBadInput(pn)
+				}
+				continue
+			}
+			if pn.value[0] != ':' {
+				break
+			}
+			if len(optional) > 0 || rest != "" {
+				return nil, nil, errorBadInput(pn)
+			}
+			params = append(params, pn.value[1:])
+			continue
+
+		case *ListNode:
+			wn, ok := pn.firstChild.(*WordNode)
+			if !ok || wn.value[0] != ':' || rest != "" {
+				return nil, nil, errorBadInput(pn)
+			}
+			if wn.next() == nil {
+				rest = wn.value[1:]
+			} else {
+				optional = append(optional, optionalInput{wn.value[1:], pn})
+			}
+			continue
 		}
-		params = append(params, wn.value[1:])
+		break
+	}
+	if arity < 0 {
+		arity = len(params)
 	}
 
 	firstNode := n
@@ -503,7 +776,59 @@ func readInterpretedProcedure(node Node) (*InterpretedProcedure, Node, error) {
 		return nil, nil, errorKeywordExpected(nil, keywordEnd)
 	}
 
-	return &InterpretedProcedure{strings.ToUpper(procName), params, firstNode, "", false, false}, n.next(), nil
+	return &InterpretedProcedure{strings.ToUpper(procName), params, firstNode, "", false, false, nil, optional, rest, arity, isMacro, titleLine, nil}, n.next(), nil
+}
+
+// tailCallFrame returns the interpreted frame that a call made at frame
+// can replace, or nil if the call is not in tail position.
+func tailCallFrame(frame Frame, next Node) *InterpretedFrame {
+
+	if next != nil {
+		return nil
+	}
+	tc := frame.tailInfo()
+	if tc == nil || !tc.tail || tc.argDepth > 0 {
+		return nil
+	}
+	f, _ := findInterpretedFrame(frame)
+	return f
+}
+
+// isTailTransparent reports whether only control builtins that pass
+// their result straight through lie between frame and its procedure.
+func isTailTransparent(frame Frame) bool {
+	for {
+		switch f := frame.(type) {
+		case *InterpretedFrame:
+			return true
+		case *BuiltInFrame:
+			if !tailTransparent[f.name] {
+				return false
+			}
+			frame = f.parent
+		default:
+			return false
+		}
+	}
+}
+
+func enterArgs(frame Frame) {
+	tc := frame.tailInfo()
+	if tc != nil {
+		tc.argDepth++
+	}
+}
+
+func leaveArgs(frame Frame) {
+	tc := frame.tailInfo()
+	if tc != nil {
+		tc.argDepth--
+	}
+}
+
+func isFrameStopped(frame Frame) bool {
+	f, _ := findInterpretedFrame(frame)
+	return f != nil && f.stopped
 }
 
 func findInterpretedFrame(frame Frame) (*InterpretedFrame, error) {
@@ -22,9 +22,11 @@ const (
 	fontSize = 24
 )
 
+type glyphRenderer func(c rune, glyphStyle int) Surface
+
 type GlyphMap struct {
-	fonts      []*ttf.Font
-	glyphs     map[int]map[rune]*sdlSurface
+	render     glyphRenderer
+	glyphs     map[int]map[rune]Surface
 	charHeight int
 	charWidth  int
 }
@@ -32,7 +34,11 @@ type GlyphMap struct {
 var textColFg sdl.Color = sdl.Color{0xFF, 0xFF, 0xFF, 0xFF}
 var textColBg sdl.Color = sdl.Color{0x00, 0x00, 0x00, 0xFF}
 
-func initGlyphMap() *GlyphMap {
+func newGlyphMap(render glyphRenderer, charHeight, charWidth int) *GlyphMap {
+	return &GlyphMap{render, make(map[int]map[rune]Surface), charHeight, charWidth}
+}
+
+func (this *sdlWindow) CreateGlyphMap() *GlyphMap {
 
 	ttf.Init()
 
@@ -40,7 +46,25 @@ func initGlyphMap() *GlyphMap {
 		ttf.OpenFont(path.Join(resourceDir, normalFontName), fontSize),
 		ttf.OpenFont(path.Join(resourceDir, boldFontName), fontSize)}
 
-	gm := &GlyphMap{fs, make(map[int]map[rune]*sdlSurface), fs[0].Height(), 0}
+	render := func(c rune, glyphStyle int) Surface {
+		fgc := textColFg
+		bgc := textColBg
+		if (glyphStyle & glyphStyleInverse) == glyphStyleInverse {
+			fgc = textColBg
+			bgc = textColFg
+		}
+
+		s := string([]rune{c})
+		var gs *sdl.Surface
+		if (glyphStyle & glyphStyleNormal) == glyphStyleNormal {
+			gs = ttf.RenderUTF8_Shaded(fs[0], s, fgc, bgc)
+		} else {
+			gs = ttf.RenderUTF8_Shaded(fs[1], s, fgc, bgc)
+		}
+		return &sdlSurface{gs, uintptr(unsafe.Pointer(gs.Pixels)), int(gs.W), int(gs.H), color.RGBA{0, 0, 0, 0}, 0}
+	}
+
+	gm := newGlyphMap(render, fs[0].Height(), 0)
 
 	g := gm.getGlyph('e', glyphStyleNormal)
 	gm.charWidth = int(g.W())
@@ -52,27 +76,13 @@ func (this *GlyphMap) getGlyph(c rune, glyphStyle int) Surface {
 
 	gm, exists := this.glyphs[glyphStyle]
 	if !exists {
-		gm = make(map[rune]*sdlSurface, 30)
+		gm = make(map[rune]Surface, 30)
 		this.glyphs[glyphStyle] = gm
 	}
 
 	g, exists := gm[c]
 	if !exists {
-		fgc := textColFg
-		bgc := textColBg
-		if (glyphStyle & glyphStyleInverse) == glyphStyleInverse {
-			fgc = textColBg
-			bgc = textColFg
-		}
-
-		s := string([]rune{c})
-		var gs *sdl.Surface
-		if (glyphStyle & glyphStyleNormal) == glyphStyleNormal {
-			gs = ttf.RenderUTF8_Shaded(this.fonts[0], s, fgc, bgc)
-		} else {
-			gs = ttf.RenderUTF8_Shaded(this.fonts[1], s, fgc, bgc)
-		}
-		g = &sdlSurface{gs, uintptr(unsafe.Pointer(gs.Pixels)), int(gs.W), int(gs.H), color.RGBA{0, 0, 0, 0}, 0}
+		g = this.render(c, glyphStyle)
 		gm[c] = g
 	}
 
@@ -89,6 +99,7 @@ func (this *GlyphMap) renderGlyph(c rune, glyphStyle int, dst Surface, x, y int)
 
 type Window interface {
 	CreateSurface(w, h int, withAlpha bool) Surface
+	CreateGlyphMap() *GlyphMap
 	DrawSurface(x, y int, sfc Surface)
 	DrawSurfacePart(dx, dy int, sfc Surface, sx, sy, sw, sh int)
 	Clear(c color.Color)
@@ -112,7 +123,7 @@ type Surface interface {
 	ColorAt(x, y int) color.Color
 	Fill(x1, y1, x2, y2 int)
 	FillTriangle(x1, y1, x2, y2, x3, y3 int)
-	Flood(x, y int) (minX, minY, maxX, maxY int)
+	Flood(x, y int) []displayRun
 	Update()
 	W() int
 	H() int
@@ -412,15 +423,15 @@ func colorEqual(c1, c2 color.Color) bool {
 	return r1 == r2 && g1 == g2 && b1 == b2
 }
 
-func (this *sdlSurface) Flood(x, y int) (minX, minY, maxX, maxY int) {
-
-	minX, minY, maxX, maxY = this.w, this.h, 0, 0
+func (this *sdlSurface) Flood(x, y int) []displayRun {
 
 	tc := this.getPixel(x, y)
 	if tc == this.sdlCol {
-		return x, y, x, y
+		return nil
 	}
 
+	runs := make([]displayRun, 0, 64)
+
 	sweep := uintptr(this.w * 4)
 
 	q := &fillNode{nil, x, y}
@@ -468,21 +479,10 @@ func (this *sdlSurface) Flood(x, y int) (minX, minY, maxX, maxY int) {
 			lc = *(*uint32)(unsafe.Pointer(p))
 		}
 
-		if x1+1 < x2-1 {
-			if x1 < minX {
-				minX = x1
-			}
-			if x2 > maxX {
-				maxX = x2
-			}
-			if sy < minY {
-				minY = sy
-			}
-			if sy > maxY {
-				maxY = sy
-			}
+		if x2-1 >= x1+1 {
+			runs = append(runs, displayRun{sy, x1 + 1, x2 - 1})
 		}
 	}
 
-	return
+	return runs
 }
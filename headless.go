@@ -0,0 +1,335 @@
+package main
+
+import (
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+const (
+	headlessDefaultW = 1024
+	headlessDefaultH = 768
+)
+
+type memWindow struct {
+	b    *MessageBroker
+	fb   *memSurface
+	clip image.Rectangle
+	w, h int
+}
+
+func newMemWindow(broker *MessageBroker, ww, wh int) Window {
+
+	if ww == 0 || wh == 0 {
+		ww = headlessDefaultW
+		wh = headlessDefaultH
+	}
+
+	fb := newMemSurface(ww, wh, false)
+
+	return &memWindow{broker, fb, fb.img.Bounds(), ww, wh}
+}
+
+func (this *memWindow) CreateSurface(w, h int, withAlpha bool) Surface {
+	return newMemSurface(w, h, withAlpha)
+}
+
+func (this *memWindow) CreateGlyphMap() *GlyphMap {
+
+	face := basicfont.Face7x13
+	_, adv, _ := face.GlyphBounds('e')
+	cw := adv.Ceil()
+	ch := face.Metrics().Height.Ceil()
+
+	render := func(c rune, glyphStyle int) Surface {
+		fgc := color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+		bgc := color.RGBA{0x00, 0x00, 0x00, 0xFF}
+		if (glyphStyle & glyphStyleInverse) == glyphStyleInverse {
+			fgc, bgc = bgc, fgc
+		}
+
+		g := newMemSurface(cw, ch, false)
+		draw.Draw(g.img, g.img.Bounds(), image.NewUniform(bgc), image.ZP, draw.Src)
+
+		d := &font.Drawer{
+			Dst:  g.img,
+			Src:  image.NewUniform(fgc),
+			Face: face,
+			Dot:  fixed.P(0, face.Metrics().Ascent.Ceil())}
+		d.DrawString(string(c))
+
+		if (glyphStyle & glyphStyleBold) == glyphStyleBold {
+			d.Dot = fixed.P(1, face.Metrics().Ascent.Ceil())
+			d.DrawString(string(c))
+		}
+
+		return g
+	}
+
+	return newGlyphMap(render, ch, cw)
+}
+
+func (this *memWindow) SetClipRect(x, y, w, h int) {
+	this.clip = image.Rect(x, y, x+w, y+h).Intersect(this.fb.img.Bounds())
+}
+
+func (this *memWindow) ClearClipRect() {
+	this.clip = this.fb.img.Bounds()
+}
+
+func (this *memWindow) DrawSurface(x, y int, sfc Surface) {
+	this.DrawSurfacePart(x, y, sfc, 0, 0, sfc.W(), sfc.H())
+}
+
+func (this *memWindow) DrawSurfacePart(dx, dy int, sfc Surface, sx, sy, sw, sh int) {
+
+	ms := sfc.(*memSurface)
+	r := image.Rect(dx, dy, dx+sw, dy+sh).Intersect(this.clip)
+	sp := image.Pt(sx+r.Min.X-dx, sy+r.Min.Y-dy)
+
+	draw.Draw(this.fb.img, r, ms.img, sp, ms.op())
+}
+
+func (this *memWindow) Clear(c color.Color) {
+	draw.Draw(this.fb.img, this.clip, image.NewUniform(c), image.ZP, draw.Src)
+}
+
+func (this *memWindow) ClearRect(c color.Color, x, y, w, h int) {
+	r := image.Rect(x, y, x+w, y+h).Intersect(this.clip)
+	draw.Draw(this.fb.img, r, image.NewUniform(c), image.ZP, draw.Src)
+}
+
+func (this *memWindow) Update() {
+}
+
+func (this *memWindow) W() int {
+	return this.w
+}
+
+func (this *memWindow) H() int {
+	return this.h
+}
+
+type memSurface struct {
+	img       *image.RGBA
+	w, h      int
+	c         color.RGBA
+	withAlpha bool
+}
+
+func newMemSurface(w, h int, withAlpha bool) *memSurface {
+
+	s := &memSurface{image.NewRGBA(image.Rect(0, 0, w, h)), w, h, color.RGBA{0, 0, 0, 255}, withAlpha}
+	s.Clear()
+
+	return s
+}
+
+func (this *memSurface) op() draw.Op {
+	if this.withAlpha {
+		return draw.Over
+	}
+	return draw.Src
+}
+
+func (this *memSurface) inBounds(x, y int) bool {
+	return x >= 0 && x < this.w && y >= 0 && y < this.h
+}
+
+func (this *memSurface) W() int {
+	return this.w
+}
+
+func (this *memSurface) H() int {
+	return this.h
+}
+
+func (this *memSurface) Clear() {
+	var c color.RGBA
+	if !this.withAlpha {
+		c = color.RGBA{0, 0, 0, 255}
+	}
+	draw.Draw(this.img, this.img.Bounds(), image.NewUniform(c), image.ZP, draw.Src)
+}
+
+func (this *memSurface) SetColor(c color.Color) {
+	this.c = color.RGBAModel.Convert(c).(color.RGBA)
+}
+
+func (this *memSurface) DrawSurface(x, y int, sfc Surface) {
+	this.DrawSurfacePart(x, y, sfc, 0, 0, sfc.W(), sfc.H())
+}
+
+func (this *memSurface) DrawSurfacePart(dx, dy int, sfc Surface, sx, sy, sw, sh int) {
+
+	ms := sfc.(*memSurface)
+	r := image.Rect(dx, dy, dx+sw, dy+sh).Intersect(this.img.Bounds())
+	sp := image.Pt(sx+r.Min.X-dx, sy+r.Min.Y-dy)
+
+	draw.Draw(this.img, r, ms.img, sp, ms.op())
+}
+
+func (this *memSurface) DrawLine(x1, y1, x2, y2 int) {
+
+	dx := x2 - x1
+	if dx < 0 {
+		dx = -dx
+	}
+	dy := y2 - y1
+	if dy < 0 {
+		dy = -dy
+	}
+	sx := -1
+	if x1 < x2 {
+		sx = 1
+	}
+	sy := -1
+	if y1 < y2 {
+		sy = 1
+	}
+	err := dx - dy
+
+	for {
+		this.DrawPoint(x1, y1)
+		if x1 == x2 && y1 == y2 {
+			break
+		}
+		e2 := 2 * err
+		if e2 > -dy {
+			err -= dy
+			x1 += sx
+		}
+		if e2 < dx {
+			err += dx
+			y1 += sy
+		}
+	}
+}
+
+func (this *memSurface) DrawPoint(x, y int) {
+	if !this.inBounds(x, y) {
+		return
+	}
+	this.img.SetRGBA(x, y, this.c)
+}
+
+func (this *memSurface) ErasePoint(x, y int) {
+	if !this.inBounds(x, y) {
+		return
+	}
+	this.img.SetRGBA(x, y, color.RGBA{})
+}
+
+func (this *memSurface) ReversePoint(x, y int) {
+	if !this.inBounds(x, y) {
+		return
+	}
+	o := this.img.RGBAAt(x, y)
+	this.img.SetRGBA(x, y, color.RGBA{o.R ^ this.c.R, o.G ^ this.c.G, o.B ^ this.c.B, this.c.A})
+}
+
+func (this *memSurface) ColorAt(x, y int) color.Color {
+	if !this.inBounds(x, y) {
+		return color.RGBA{}
+	}
+	return this.img.RGBAAt(x, y)
+}
+
+func (this *memSurface) Fill(x1, y1, x2, y2 int) {
+	x1, x2 = sortInts(x1, x2)
+	y1, y2 = sortInts(y1, y2)
+	r := image.Rect(x1, y1, x2+1, y2+1).Intersect(this.img.Bounds())
+	draw.Draw(this.img, r, image.NewUniform(this.c), image.ZP, draw.Src)
+}
+
+func edgeSide(ax, ay, bx, by, px, py int) int {
+	return (bx-ax)*(py-ay) - (by-ay)*(px-ax)
+}
+
+func (this *memSurface) FillTriangle(x1, y1, x2, y2, x3, y3 int) {
+
+	minX := intMax(intMin(x1, intMin(x2, x3)), 0)
+	maxX := intMin(intMax(x1, intMax(x2, x3)), this.w-1)
+	minY := intMax(intMin(y1, intMin(y2, y3)), 0)
+	maxY := intMin(intMax(y1, intMax(y2, y3)), this.h-1)
+
+	area := edgeSide(x1, y1, x2, y2, x3, y3)
+	if area == 0 {
+		this.DrawLine(x1, y1, x2, y2)
+		this.DrawLine(x2, y2, x3, y3)
+		return
+	}
+
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			e1 := edgeSide(x2, y2, x3, y3, x, y)
+			e2 := edgeSide(x3, y3, x1, y1, x, y)
+			e3 := edgeSide(x1, y1, x2, y2, x, y)
+			if area > 0 && e1 >= 0 && e2 >= 0 && e3 >= 0 ||
+				area < 0 && e1 <= 0 && e2 <= 0 && e3 <= 0 {
+				this.img.SetRGBA(x, y, this.c)
+			}
+		}
+	}
+}
+
+func (this *memSurface) Flood(x, y int) []displayRun {
+
+	if !this.inBounds(x, y) {
+		return nil
+	}
+
+	tc := this.img.RGBAAt(x, y)
+	if tc == this.c {
+		return nil
+	}
+
+	runs := make([]displayRun, 0, 64)
+
+	q := &fillNode{nil, x, y}
+	for q != nil {
+		sx, sy := q.x, q.y
+		q = q.n
+
+		if this.img.RGBAAt(sx, sy) != tc {
+			continue
+		}
+
+		x1 := sx
+		for x1 > 0 && this.img.RGBAAt(x1-1, sy) == tc {
+			x1--
+		}
+		x2 := sx
+		for x2 < this.w-1 && this.img.RGBAAt(x2+1, sy) == tc {
+			x2++
+		}
+
+		ux := -2
+		dx := -2
+		for fx := x1; fx <= x2; fx++ {
+			this.img.SetRGBA(fx, sy, this.c)
+			if sy > 0 && this.img.RGBAAt(fx, sy-1) == tc {
+				if ux != fx-1 {
+					q = &fillNode{q, fx, sy - 1}
+				}
+				ux = fx
+			}
+			if sy < this.h-1 && this.img.RGBAAt(fx, sy+1) == tc {
+				if dx != fx-1 {
+					q = &fillNode{q, fx, sy + 1}
+				}
+				dx = fx
+			}
+		}
+
+		runs = append(runs, displayRun{sy, x1, x2})
+	}
+
+	return runs
+}
+
+func (this *memSurface) Update() {
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"image/color"
+	"image/png"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func assertColorAt(t *testing.T, s Surface, x, y int, expected color.RGBA) {
+
+	c := color.RGBAModel.Convert(s.ColorAt(x, y)).(color.RGBA)
+	if c != expected {
+		t.Errorf("(%d,%d): Expected %v was %v", x, y, expected, c)
+	}
+}
+
+func TestMemSurfaceDrawLine(t *testing.T) {
+
+	s := newMemSurface(10, 10, true)
+	s.SetColor(colorWhite)
+	s.DrawLine(0, 0, 9, 9)
+
+	assertColorAt(t, s, 0, 0, colorWhite)
+	assertColorAt(t, s, 5, 5, colorWhite)
+	assertColorAt(t, s, 9, 9, colorWhite)
+	assertColorAt(t, s, 9, 0, color.RGBA{})
+}
+
+func TestMemSurfaceFlood(t *testing.T) {
+
+	s := newMemSurface(10, 10, true)
+	s.SetColor(colorWhite)
+	s.DrawLine(0, 5, 9, 5)
+
+	s.SetColor(colorGreen)
+	runs := s.Flood(2, 2)
+
+	assertColorAt(t, s, 0, 0, colorGreen)
+	assertColorAt(t, s, 9, 4, colorGreen)
+	assertColorAt(t, s, 4, 5, colorWhite)
+	assertColorAt(t, s, 4, 6, color.RGBA{})
+
+	if len(runs) != 5 {
+		t.Errorf("Expected 5 runs was %d", len(runs))
+	}
+	x1, y1, x2, y2 := runBounds(runs)
+	if x1 != 0 || y1 != 0 || x2 != 9 || y2 != 4 {
+		t.Errorf("Expected bounds (0,0,9,4) was (%d,%d,%d,%d)", x1, y1, x2, y2)
+	}
+}
+
+func TestMemSurfaceFloodRuns(t *testing.T) {
+
+	s := newMemSurface(10, 10, true)
+	s.SetColor(colorGreen)
+	s.DrawLine(0, 7, 3, 7)
+
+	runs := s.Flood(8, 8)
+	for _, r := range runs {
+		if r.y == 7 && (r.x1 != 4 || r.x2 != 9) {
+			t.Errorf("Expected the run on line 7 to be 4-9 was %d-%d", r.x1, r.x2)
+		}
+	}
+}
+
+func TestMemSurfaceFillTriangle(t *testing.T) {
+
+	s := newMemSurface(10, 10, true)
+	s.SetColor(colorWhite)
+	s.FillTriangle(0, 0, 9, 0, 0, 9)
+
+	assertColorAt(t, s, 1, 1, colorWhite)
+	assertColorAt(t, s, 9, 9, color.RGBA{})
+}
+
+func TestMemWindowDrawSurfacePart(t *testing.T) {
+
+	w := newMemWindow(nil, 10, 10)
+	s := w.CreateSurface(4, 4, false)
+	s.SetColor(colorWhite)
+	s.Fill(0, 0, 3, 3)
+
+	w.SetClipRect(0, 0, 5, 5)
+	w.DrawSurfacePart(3, 3, s, 0, 0, 4, 4)
+
+	fb := w.(*memWindow).fb
+	assertColorAt(t, fb, 3, 3, colorWhite)
+	assertColorAt(t, fb, 4, 4, colorWhite)
+	assertColorAt(t, fb, 5, 5, colorBlack)
+}
+
+func newHeadlessWorkspace(t *testing.T) *Workspace {
+
+	hws := CreateWorkspace()
+	hws.OpenScreen(200, 200, true)
+	err := hws.files.SetPrefix(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hws
+}
+
+func TestSaveAndLoadPic(t *testing.T) {
+
+	hws := newHeadlessWorkspace(t)
+	err := hws.readString("FD 50\nSAVEPIC \"pic.png\nCS\nHT\nLOADPIC \"pic.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path.Join(hws.files.rootPath, "pic.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := hws.turtle
+	x := tr.normX(0)
+	y := tr.normY(25)
+	if c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA); c != tr.penColor {
+		t.Errorf("Expected the saved line in %v was %v", tr.penColor, c)
+	}
+	assertColorAt(t, tr.image, x, y, tr.penColor)
+}
+
+func TestSaveSvg(t *testing.T) {
+
+	hws := newHeadlessWorkspace(t)
+	err := hws.readString("FD 50\nPENREVERSE\nRT 90\nFD 50\nSAVESVG \"pic.svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path.Join(hws.files.rootPath, "pic.svg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg := string(b)
+	if strings.Count(svg, "<path") != 1 || strings.Count(svg, ".5L") != 1 {
+		t.Errorf("Expected only the line drawn with the pen down, was %s", svg)
+	}
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"bufio"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
 type File interface {
@@ -25,6 +29,7 @@ type Files struct {
 	reader      File
 	writer      File
 	dribble     File
+	jail        string
 }
 
 func CreateFiles(rootPath string) *Files {
@@ -47,7 +52,7 @@ func CreateFiles(rootPath string) *Files {
 		rootPath,
 		1,
 		make(map[string]File),
-		df, df, df, nil}
+		df, df, df, nil, ""}
 
 	return f
 }
@@ -61,18 +66,48 @@ func (this *Files) write(s string) {
 	this.writer.Write(s)
 }
 
-func (this *Files) normPath(p string) string {
+// normPath makes a file name absolute, relative to the prefix. When the
+// files are confined to a directory, paths outside of it are refused.
+func (this *Files) normPath(p string) (string, error) {
 	p = path.Clean(p)
 	if !path.IsAbs(p) {
 		p = path.Join(this.rootPath, p)
 	}
 
-	return p
+	if this.jail != "" {
+		rp, err := resolvePath(p)
+		if err != nil || (rp != this.jail && !strings.HasPrefix(rp, this.jail+"/")) {
+			return "", errorOutsideSandbox(p)
+		}
+	}
+	return p, nil
+}
+
+// resolvePath follows the symbolic links in p. Only the part of the path that
+// exists can be followed, so the rest is added back as it is; a link that
+// points nowhere is an error, as creating the file would follow it.
+func resolvePath(p string) (string, error) {
+
+	rest := ""
+	for {
+		rp, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return path.Join(rp, rest), nil
+		}
+		if _, lerr := os.Lstat(p); !os.IsNotExist(lerr) {
+			return "", err
+		}
+		rest = path.Join(path.Base(p), rest)
+		p = path.Dir(p)
+	}
 }
 
 func (this *Files) SetPrefix(prefix string) error {
 
-	p := this.normPath(prefix)
+	p, err := this.normPath(prefix)
+	if err != nil {
+		return err
+	}
 
 	f, err := os.Stat(p)
 	if err != nil {
@@ -89,9 +124,12 @@ func (this *Files) SetPrefix(prefix string) error {
 
 func (this *Files) CreateDir(path string) error {
 
-	p := this.normPath(path)
+	p, err := this.normPath(path)
+	if err != nil {
+		return err
+	}
 
-	_, err := os.Stat(p)
+	_, err = os.Stat(p)
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
@@ -121,9 +159,12 @@ func (this *Files) Catalog() error {
 
 func (this *Files) EraseFile(name string) error {
 
-	p := this.normPath(name)
+	p, err := this.normPath(name)
+	if err != nil {
+		return err
+	}
 
-	_, err := os.Stat(p)
+	_, err = os.Stat(p)
 	if err != nil {
 		return err
 	}
@@ -132,7 +173,10 @@ func (this *Files) EraseFile(name string) error {
 }
 
 func (this *Files) IsFile(name string) bool {
-	p := this.normPath(name)
+	p, err := this.normPath(name)
+	if err != nil {
+		return false
+	}
 
 	f, err := os.Stat(p)
 	if err != nil {
@@ -143,40 +187,104 @@ func (this *Files) IsFile(name string) bool {
 }
 
 func (this *Files) Rename(from, to string) error {
-	fp := this.normPath(from)
-	tp := this.normPath(to)
+	fp, err := this.normPath(from)
+	if err != nil {
+		return err
+	}
+	tp, err := this.normPath(to)
+	if err != nil {
+		return err
+	}
 
 	return os.Rename(fp, tp)
 }
 
 func (this *Files) OpenFile(name string) error {
-	name = this.normPath(name)
+	return this.openFile(name, os.O_RDWR|os.O_CREATE)
+}
+
+func (this *Files) CreateFile(name string) error {
+	return this.openFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+}
+
+func (this *Files) openFile(name string, flag int) error {
+	p, err := this.normPath(name)
+	if err != nil {
+		return err
+	}
 
-	_, exists := this.openFiles[name]
+	_, exists := this.openFiles[p]
 	if exists {
 		return nil
 	}
 
-	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+	nf, err := openNormalFile(this.nextId, name, p, flag)
 	if err != nil {
 		return err
 	}
 
-	this.openFiles[name] = &NormalFile{
-		this.nextId, name, f, bufio.NewReader(f)}
+	this.openFiles[p] = nf
+	this.nextId++
+
+	return nil
+}
+
+func (this *Files) Dribble(name string) error {
+	if this.dribble != nil {
+		return errorAlreadyDribbling()
+	}
 
+	p, err := this.normPath(name)
+	if err != nil {
+		return err
+	}
+
+	nf, err := openNormalFile(this.nextId, name, p, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+
+	this.dribble = nf
 	this.nextId++
 
 	return nil
 }
 
+func (this *Files) NoDribble() error {
+	if this.dribble == nil {
+		return nil
+	}
+
+	err := this.dribble.Close()
+	this.dribble = nil
+	return err
+}
+
+func (this *Files) writeDribble(text string) {
+	if this.dribble != nil {
+		this.dribble.Write(text)
+	}
+}
+
+func (this *Files) OpenFileNames() []string {
+	names := make([]string, 0, len(this.openFiles))
+	for _, f := range this.openFiles {
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (this *Files) GetFile(name string) (File, error) {
 
 	if name == "" {
 		return this.defaultFile, nil
 	}
 
-	name = this.normPath(name)
+	name, err := this.normPath(name)
+	if err != nil {
+		return nil, err
+	}
 	f, ok := this.openFiles[name]
 	if !ok {
 		return nil, errorFileNotOpen(name)
@@ -190,7 +298,10 @@ func (this *Files) CloseFile(name string) error {
 		return nil
 	}
 
-	name = this.normPath(name)
+	name, err := this.normPath(name)
+	if err != nil {
+		return err
+	}
 	f, ok := this.openFiles[name]
 	if !ok {
 		return errorFileNotOpen(name)
@@ -209,12 +320,25 @@ func (this *Files) CloseFile(name string) error {
 	return nil
 }
 
+func (this *Files) CloseAll() {
+	for _, f := range this.openFiles {
+		f.Close()
+	}
+	this.openFiles = make(map[string]File)
+	this.reader = this.defaultFile
+	this.writer = this.defaultFile
+}
+
 func (this *Files) SetReader(name string) error {
 	if name == "" {
 		this.reader = this.defaultFile
+		return nil
 	}
 
-	name = this.normPath(name)
+	name, err := this.normPath(name)
+	if err != nil {
+		return err
+	}
 	f, ok := this.openFiles[name]
 	if !ok {
 		return errorFileNotOpen(name)
@@ -228,9 +352,13 @@ func (this *Files) SetWriter(name string) error {
 
 	if name == "" {
 		this.writer = this.defaultFile
+		return nil
 	}
 
-	name = this.normPath(name)
+	name, err := this.normPath(name)
+	if err != nil {
+		return err
+	}
 	f, ok := this.openFiles[name]
 	if !ok {
 		return errorFileNotOpen(name)
@@ -240,11 +368,44 @@ func (this *Files) SetWriter(name string) error {
 	return nil
 }
 
+type fileSource struct {
+	f   *os.File
+	pos int64
+}
+
+func (this *fileSource) Read(p []byte) (int, error) {
+	n, err := this.f.ReadAt(p, this.pos)
+	this.pos += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
 type NormalFile struct {
-	id   int
-	name string
-	f    *os.File
-	r    *bufio.Reader
+	id       int
+	name     string
+	f        *os.File
+	src      *fileSource
+	r        *bufio.Reader
+	writePos int64
+}
+
+func openNormalFile(id int, name, p string, flag int) (*NormalFile, error) {
+
+	f, err := os.OpenFile(p, flag, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	src := &fileSource{f, 0}
+	return &NormalFile{id, name, f, src, bufio.NewReader(src), fi.Size()}, nil
 }
 
 func (this *NormalFile) Id() int {
@@ -252,7 +413,32 @@ func (this *NormalFile) Id() int {
 }
 
 func (this *NormalFile) Name() string {
-	return ""
+	return this.name
+}
+
+func (this *NormalFile) ReadPos() int64 {
+	return this.src.pos - int64(this.r.Buffered())
+}
+
+func (this *NormalFile) SetReadPos(pos int64) {
+	this.src.pos = pos
+	this.r.Reset(this.src)
+}
+
+func (this *NormalFile) WritePos() int64 {
+	return this.writePos
+}
+
+func (this *NormalFile) SetWritePos(pos int64) {
+	this.writePos = pos
+}
+
+func (this *NormalFile) Len() (int64, error) {
+	fi, err := this.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
 }
 
 func (this *NormalFile) ReadLine() (string, error) {
@@ -270,7 +456,8 @@ func (this *NormalFile) ReadChar() (rune, error) {
 
 func (this *NormalFile) Write(text string) error {
 
-	_, err := this.f.WriteString(text)
+	n, err := this.f.WriteAt([]byte(text), this.writePos)
+	this.writePos += int64(n)
 	return err
 }
 
@@ -284,12 +471,12 @@ func (this *NormalFile) IsInteractive() bool {
 
 type StdIOFile struct {
 	id     int
-	stdin  *os.File
+	stdin  *bufio.Reader
 	stdout *os.File
 }
 
 func NewStdIOFile() *StdIOFile {
-	return &StdIOFile{0, os.Stdin, os.Stdout}
+	return &StdIOFile{0, bufio.NewReader(os.Stdin), os.Stdout}
 }
 
 func (this *StdIOFile) Id() int {
@@ -301,8 +488,7 @@ func (this *StdIOFile) Name() string {
 }
 
 func (this *StdIOFile) ReadLine() (string, error) {
-	r := bufio.NewReader(this.stdin)
-	line, _, err := r.ReadLine()
+	line, _, err := this.stdin.ReadLine()
 	if err != nil {
 		return "", err
 	}
@@ -310,8 +496,7 @@ func (this *StdIOFile) ReadLine() (string, error) {
 }
 
 func (this *StdIOFile) ReadChar() (rune, error) {
-	r := bufio.NewReader(this.stdin)
-	c, _, err := r.ReadRune()
+	c, _, err := this.stdin.ReadRune()
 	return c, err
 }
 
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// useTempPrefix points the test workspace at an empty directory, returning
+// a function that restores the prefix.
+func useTempPrefix(t *testing.T) func() {
+
+	old := ws.files.rootPath
+	err := ws.files.SetPrefix(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() { ws.files.rootPath = old }
+}
+
+func TestDribble(t *testing.T) {
+
+	defer useTempPrefix(t)()
+
+	err := ws.readString("DRIBBLE \"transcript.txt\nPRINT \"shown\n" +
+		"OPEN \"other.txt\nSETWRITE \"other.txt\nPRINT \"hidden\nSETWRITE []\nCLOSE \"other.txt\n" +
+		"NODRIBBLE")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path.Join(ws.files.rootPath, "transcript.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "shown") {
+		t.Errorf("Expected the transcript to contain shown, was %q", string(b))
+	}
+	if strings.Contains(string(b), "hidden") {
+		t.Errorf("Output written to a file was copied to the transcript: %q", string(b))
+	}
+}
+
+func TestFileStreams(t *testing.T) {
+
+	defer useTempPrefix(t)()
+
+	err := ws.readString("OPEN \"streams.txt\nSETWRITE \"streams.txt\nPRINT \"hello\nPRINT 42\n" +
+		"SETWRITE []\nCLOSE \"streams.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ws.readString("OPEN \"streams.txt\nSETREAD \"streams.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExpression(t, "FILELEN \"streams.txt", "9")
+	assertExpressionOnce(t, "READWORD", "hello")
+	assertExpressionOnce(t, "READPOS", "6")
+	assertExpressionOnce(t, "READWORD", "42")
+	err = ws.readString("SETREADPOS 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExpressionOnce(t, "READWORD", "llo")
+	assertExpression(t, "READER", "streams.txt")
+	err = ws.readString("SETREAD []\nCLOSE \"streams.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExpression(t, "CATCH \"ERROR [SETREAD \"streams.txt] FIRST ERROR", "15")
+}
+
+func TestProfileReportFile(t *testing.T) {
+
+	defer useTempPrefix(t)()
+
+	err := ws.readString("TO PRFILE :x\nOUTPUT :x + 1\nEND\n" +
+		"PROFILE MAKE \"prx PRFILE 1 NOPROFILE\n" +
+		"OPEN \"mine.txt\nSETWRITE \"mine.txt\n(PROFILEREPORT \"report.txt)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExpression(t, "WRITER", "mine.txt")
+	assertExpression(t, "ALLOPEN", "[ mine.txt ]")
+	err = ws.readString("SETWRITE []\nCLOSE \"mine.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path.Join(ws.files.rootPath, "report.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "PRFILE") {
+		t.Errorf("Expected the report to list PRFILE, was %q", string(b))
+	}
+
+	err = ws.readString("OPEN \"report.txt\n(PROFILEREPORT \"report.txt)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExpression(t, "ALLOPEN", "[ report.txt ]")
+	err = ws.readString("CLOSE \"report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
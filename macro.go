@@ -0,0 +1,42 @@
+package main
+
+// expandMacro runs the list output by a macro as if it had been written in
+// place of the call, in the frame that called the macro. Tail calls are
+// not made from the expansion since the rest of the caller may still have
+// to run.
+func expandMacro(frame Frame, caller *WordNode, rv *CallResult) *CallResult {
+
+	if rv != nil && rv.hasError() {
+		return rv
+	}
+	if rv == nil || rv.returnValue == nil {
+		return errorResult(errorMacroOutput(caller))
+	}
+	ln, ok := rv.returnValue.(*ListNode)
+	if !ok {
+		return errorResult(errorMacroOutput(caller))
+	}
+
+	frame.workspace().currentFrame = frame
+	enterArgs(frame)
+	rv = evalInstructionList(frame, ln, true)
+	leaveArgs(frame)
+	return rv
+}
+
+func _bi_DefMacro(frame Frame, parameters []Node) *CallResult {
+
+	err := defineProcedure(frame.workspace(), keywordMacro, parameters[0], parameters[1])
+	if err != nil {
+		return errorResult(err)
+	}
+	return nil
+}
+
+func _bi_Macrop(frame Frame, parameters []Node) *CallResult {
+
+	return procedureIs(frame, parameters[0], func(p Procedure) bool {
+		ip, ok := p.(*InterpretedProcedure)
+		return ok && ip.macro
+	})
+}
@@ -2,15 +2,61 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
 	"runtime/pprof"
 )
 
+func runFile(ws *Workspace, name string, args []string) int {
+
+	p, err := filepath.Abs(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var fn Node
+	var ln Node
+	for _, a := range args {
+		n := newWordNode(-1, -1, a, true)
+		if fn == nil {
+			fn = n
+		} else {
+			ln.addNode(n)
+		}
+		ln = n
+	}
+	ws.rootFrame.getVars().setVariable(ws.rootFrame, "COMMAND.LINE", newListNode(-1, -1, fn))
+
+	err = ws.RunFile(p)
+	if err != nil {
+		le, ok := err.(*LogoError)
+		if ok && le.hasPosition() {
+			fmt.Fprintf(os.Stderr, "%s:%d:%d: %s\n", name, le.line, le.col, le.message)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+		}
+		return 1
+	}
+	return 0
+}
+
 func main() {
 
 	cpuprofile := flag.String("cpuprofile", "", "write profiling info to file.")
 	w := flag.Int("w", 0, "screen width.")
 	h := flag.Int("h", 0, "screen height.")
+	headless := flag.Bool("headless", false, "render off-screen without opening a window.")
+	sandbox := flag.Bool("sandbox", false, "confine file access to the prefix directory.")
+	maxInstructions := flag.Int64("maxinstructions", 0, "stop after this many procedure calls, 0 for no limit.")
+	maxTime := flag.Duration("maxtime", 0, "stop after running for this long, 0 for no limit.")
+	maxValueSize := flag.Int64("maxvaluesize", 0, "stop when a primitive outputs a word longer than this many bytes or a list with more items, 0 for no limit.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags]\n       %s [flags] run file.lg [args]\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
 
 	flag.Parse()
 	if *cpuprofile != "" {
@@ -19,10 +65,28 @@ func main() {
 			panic(err)
 		}
 		pprof.StartCPUProfile(f)
-		defer pprof.StopCPUProfile()
 	}
 
+	exitCode := 0
 	ws := CreateWorkspace()
-	ws.OpenScreen(*w, *h)
-	ws.RunInterpreter()
+	if *sandbox || *maxInstructions > 0 || *maxTime > 0 || *maxValueSize > 0 {
+		ws.SetSandbox(Sandbox{*maxInstructions, *maxTime, *maxValueSize, *sandbox})
+	}
+	if flag.Arg(0) == "run" {
+		if flag.NArg() < 2 {
+			flag.Usage()
+			exitCode = 2
+		} else {
+			ws.OpenScreen(*w, *h, true)
+			exitCode = runFile(ws, flag.Arg(1), flag.Args()[2:])
+		}
+	} else {
+		ws.OpenScreen(*w, *h, *headless)
+		ws.RunInterpreter()
+	}
+
+	if *cpuprofile != "" {
+		pprof.StopCPUProfile()
+	}
+	os.Exit(exitCode)
 }
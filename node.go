@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"fmt"
 	"strconv"
 	"strings"
 )
@@ -13,6 +12,7 @@ const (
 	Word NodeType = iota
 	List
 	Group
+	Array
 )
 
 type NodeEnumerator struct {
@@ -48,6 +48,8 @@ func (this *NodeEnumerator) nextWord() *WordNode {
 		case *GroupNode:
 			this.nodes = append(this.nodes, n)
 			n = nn.firstChild
+		case *ArrayNode:
+			n = n.next()
 		}
 	}
 
@@ -74,11 +76,21 @@ type BaseNode struct {
 	col  int
 }
 
+const (
+	numberUnknown = iota
+	numberValid
+	numberInvalid
+)
+
 type WordNode struct {
 	BaseNode
 	value          string
 	isLiteral      bool
+	isQuoted       bool
 	isFirstOfGroup bool
+	numberState    int
+	num            numeric
+	unquoted       *WordNode
 }
 
 func newWordNode(line, col int, value string, isLiteral bool) *WordNode {
@@ -107,15 +119,63 @@ func (this *WordNode) String() string {
 
 func (this *WordNode) clone() Node {
 	n := newWordNode(this.line, this.col, this.value, this.isLiteral)
+	n.isQuoted = this.isQuoted
 	n.isFirstOfGroup = this.isFirstOfGroup
+	n.numberState = this.numberState
+	n.num = this.num
 	return n
 }
 
 func (this *WordNode) setLiteral() { this.isLiteral = true }
 
+// datum returns the word that a quoted word evaluates to. It is a copy that
+// no longer counts as quoted, so that a list built from it runs the word as
+// if it had been typed. The copy is made once and kept.
+func (this *WordNode) datum() *WordNode {
+	if !this.isQuoted {
+		return this
+	}
+	if this.unquoted == nil {
+		n := this.clone().(*WordNode)
+		n.isQuoted = false
+		n.isFirstOfGroup = false
+		this.unquoted = n
+	}
+	return this.unquoted
+}
+
+// number returns the numeric value of the word. The value is parsed the
+// first time the word is used as a number and cached from then on.
+func (this *WordNode) number() (float64, bool) {
+
+	n, ok := this.numeric()
+	return n.float(), ok
+}
+
+// numeric returns the exact value of the word, see number.
+func (this *WordNode) numeric() (numeric, bool) {
+
+	if this.numberState == numberUnknown {
+		r, ok := parseNumeric(this.value)
+		if !ok {
+			this.numberState = numberInvalid
+		} else {
+			this.numberState = numberValid
+			this.num = r
+		}
+	}
+	return this.num, this.numberState == numberValid
+}
+
+// Lists are never changed once they have been built, so the children of
+// one list may be shared with another: FPUT and BUTFIRST reuse the chain
+// of the list they are given. The length and an index of the children are
+// worked out the first time they are needed.
 type ListNode struct {
 	BaseNode
 	firstChild Node
+	count      int
+	items      []Node
 }
 
 func newListNode(line, col int, firstChild Node) *ListNode {
@@ -123,6 +183,7 @@ func newListNode(line, col int, firstChild Node) *ListNode {
 	n.BaseNode.line = line
 	n.BaseNode.col = col
 	n.firstChild = firstChild
+	n.count = -1
 
 	return n
 }
@@ -138,23 +199,35 @@ func (this *ListNode) addNode(node Node) {
 func (this *ListNode) position() (int, int) { return this.line, this.col }
 
 func (this *ListNode) String() string {
-	s := "[ "
-	n := this.firstChild
-	for n != nil {
-		s += n.String() + " "
-		n = n.next()
+	var b bytes.Buffer
+	b.WriteString("[ ")
+	for n := this.firstChild; n != nil; n = n.next() {
+		b.WriteString(n.String())
+		b.WriteString(" ")
 	}
-	s += "]"
+	b.WriteString("]")
 
-	return s
+	return b.String()
 }
 
 func (this *ListNode) length() int {
-	n := 0
-	for c := this.firstChild; c != nil; c = c.next() {
-		n++
+	if this.count < 0 {
+		this.count = len(this.index())
 	}
-	return n
+	return this.count
+}
+
+// index returns the children of the list as a slice.
+func (this *ListNode) index() []Node {
+	if this.items == nil && this.firstChild != nil {
+		items := make([]Node, 0, intMax(this.count, 4))
+		for c := this.firstChild; c != nil; c = c.next() {
+			items = append(items, c)
+		}
+		this.items = items
+		this.count = len(items)
+	}
+	return this.items
 }
 
 func (this *ListNode) clone() Node {
@@ -243,6 +316,63 @@ func (this *GroupNode) setLiteral() {
 	}
 }
 
+// Arrays are mutable, so an ArrayNode refers to its storage rather than
+// holding it. Copies of the node are the same array.
+type ArrayNode struct {
+	BaseNode
+	array *arrayData
+}
+
+type arrayData struct {
+	items  []Node
+	origin int
+}
+
+func newArrayNode(line, col int, items []Node, origin int) *ArrayNode {
+	n := &ArrayNode{}
+	n.BaseNode.line = line
+	n.BaseNode.col = col
+	n.array = &arrayData{items, origin}
+
+	return n
+}
+
+func (this *ArrayNode) nodeType() NodeType { return Array }
+
+func (this *ArrayNode) next() Node { return this.BaseNode.next }
+
+func (this *ArrayNode) addNode(node Node) {
+	this.BaseNode.next = node
+}
+
+func (this *ArrayNode) position() (int, int) { return this.line, this.col }
+
+func (this *ArrayNode) String() string {
+	var b bytes.Buffer
+	b.WriteString("{ ")
+	for _, n := range this.array.items {
+		b.WriteString(n.String())
+		b.WriteString(" ")
+	}
+	b.WriteString("}")
+	if this.array.origin != 1 {
+		b.WriteString("@" + strconv.Itoa(this.array.origin))
+	}
+
+	return b.String()
+}
+
+func (this *ArrayNode) clone() Node {
+	n := &ArrayNode{}
+	n.BaseNode.line = this.line
+	n.BaseNode.col = this.col
+	n.array = this.array
+
+	return n
+}
+
+func (this *ArrayNode) setLiteral() {}
+
 func printNode(ws *Workspace, n Node, includeBrackets bool) {
 	buf := &bytes.Buffer{}
 
@@ -292,6 +422,9 @@ func nodeToText(buf *bytes.Buffer, n Node, includeBrackets bool) {
 		if includeBrackets {
 			buf.WriteString("]")
 		}
+
+	case *ArrayNode:
+		buf.WriteString(pn.String())
 	}
 }
 
@@ -323,8 +456,8 @@ func evalToNumber(node Node) (float64, error) {
 
 	switch pn := node.(type) {
 	case *WordNode:
-		r, err := strconv.ParseFloat(pn.value, 64)
-		if err != nil {
+		r, ok := pn.number()
+		if !ok {
 			return 0, errorBadInput(node)
 		}
 		return r, nil
@@ -395,11 +528,11 @@ func nodesEqual(x, y Node, numericCompare bool) bool {
 		wy := y.(*WordNode)
 
 		if numericCompare {
-			nx, ex := evalToNumber(wx)
-			ny, ey := evalToNumber(wy)
+			nx, ex := evalToNumeric(wx)
+			ny, ey := evalToNumeric(wy)
 
 			if ex == nil && ey == nil {
-				return nx == ny
+				return compareNumeric(nx, ny) == 0
 			}
 		}
 
@@ -424,13 +557,56 @@ func nodesEqual(x, y Node, numericCompare bool) bool {
 			cx = cx.next()
 			cy = cy.next()
 		}
+
+	case Array:
+		return x.(*ArrayNode).array == y.(*ArrayNode).array
 	}
 
 	return true
 }
 
+// copyNode returns a copy of a node that can be linked into a new list.
+// The children of lists and groups are shared rather than copied.
+func copyNode(node Node) Node {
+
+	switch n := node.(type) {
+	case *ListNode:
+		c := newListNode(n.line, n.col, n.firstChild)
+		c.count = n.count
+		c.items = n.items
+		return c
+	case *GroupNode:
+		return newGroupNode(n.line, n.col, n.firstChild)
+	}
+	return node.clone()
+}
+
+// nodesToList builds a new list from copies of nodes.
+func nodesToList(nodes []Node) *ListNode {
+
+	items := make([]Node, len(nodes))
+	for ix, n := range nodes {
+		items[ix] = copyNode(n)
+		if ix > 0 {
+			items[ix-1].addNode(items[ix])
+		}
+	}
+
+	var fn Node
+	if len(items) > 0 {
+		fn = items[0]
+	}
+	ln := newListNode(-1, -1, fn)
+	ln.count = len(items)
+	ln.items = items
+	return ln
+}
+
+// createNumericNode is used for the numbers output by primitives. Whole
+// values are taken as exact integers, which suits the counts and positions
+// that most of them output.
 func createNumericNode(n float64) Node {
-	return newWordNode(-1, -1, fmt.Sprint(n), true)
+	return createNumberNode(integralNumeric(n))
 }
 
 func dumpNodes(frame Frame, n Node, max int) {
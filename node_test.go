@@ -95,3 +95,26 @@ func TestEnumerateMultipleLines(t *testing.T) {
 	assertWords(t, n, err, "circle", ":n", "1", "circle", ":n", "-1", "circs", ":n", "/", "2")
 
 }
+
+func TestWordNumber(t *testing.T) {
+
+	wn := newWordNode(-1, -1, "2.5", true)
+	n, ok := wn.number()
+	if !ok || n != 2.5 {
+		t.Errorf("Expected 2.5 was %v", n)
+	}
+
+	_, ok = newWordNode(-1, -1, "abc", true).number()
+	if ok {
+		t.Errorf("Expected abc not to be a number")
+	}
+
+	cn := createNumericNode(4e10).(*WordNode)
+	if cn.value != "40000000000" {
+		t.Errorf("Expected \"40000000000\" was \"%s\"", cn.value)
+	}
+	n, ok = cn.clone().(*WordNode).number()
+	if !ok || n != 4e10 {
+		t.Errorf("Expected 4e10 was %v", n)
+	}
+}
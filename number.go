@@ -0,0 +1,279 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Numbers stay exact while they are integers, moving from int64 to big
+// integers when they overflow. A result is a float when any input was a
+// float or a fractional result is produced, and stays a float after that.
+const (
+	numericInteger = iota
+	numericBig
+	numericFloat
+)
+
+// The largest power, in bits, that POW will work out exactly.
+const maxExactPowerBits = 1 << 22
+
+type numeric struct {
+	kind int
+	i    int64
+	b    *big.Int
+	f    float64
+}
+
+func integerNumeric(i int64) numeric {
+	return numeric{numericInteger, i, nil, 0}
+}
+
+func bigNumeric(b *big.Int) numeric {
+	if b.IsInt64() {
+		return integerNumeric(b.Int64())
+	}
+	return numeric{numericBig, 0, b, 0}
+}
+
+// floatNumeric holds an inexact value. Whole results of float arithmetic
+// stay floats, since their digits can't be trusted.
+func floatNumeric(f float64) numeric {
+	return numeric{numericFloat, 0, nil, f}
+}
+
+// maxExactFloat is the largest magnitude below which a float64 holds every
+// whole number exactly.
+const maxExactFloat = 1 << 53
+
+// integralNumeric is for values that are whole by nature, such as the
+// result of INT or a count, which are exact when a float can hold them.
+func integralNumeric(f float64) numeric {
+	if f == math.Trunc(f) && math.Abs(f) <= maxExactFloat {
+		return integerNumeric(int64(f))
+	}
+	return floatNumeric(f)
+}
+
+func parseNumeric(s string) (numeric, bool) {
+
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err == nil {
+		return integerNumeric(i), true
+	}
+	ne, ok := err.(*strconv.NumError)
+	if ok && ne.Err == strconv.ErrRange {
+		b, ok := new(big.Int).SetString(s, 10)
+		if ok {
+			return bigNumeric(b), true
+		}
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return numeric{}, false
+	}
+	return numeric{numericFloat, 0, nil, f}, true
+}
+
+func (this numeric) isExact() bool { return this.kind != numericFloat }
+
+func (this numeric) float() float64 {
+	switch this.kind {
+	case numericInteger:
+		return float64(this.i)
+	case numericBig:
+		f, _ := new(big.Float).SetInt(this.b).Float64()
+		return f
+	}
+	return this.f
+}
+
+func (this numeric) bigInt() *big.Int {
+	if this.kind == numericBig {
+		return this.b
+	}
+	return big.NewInt(this.i)
+}
+
+func (this numeric) String() string {
+	switch this.kind {
+	case numericInteger:
+		return strconv.FormatInt(this.i, 10)
+	case numericBig:
+		return this.b.String()
+	}
+	return strconv.FormatFloat(this.f, 'g', -1, 64)
+}
+
+func addNumeric(x, y numeric) numeric {
+	if x.kind == numericInteger && y.kind == numericInteger {
+		s := x.i + y.i
+		if (s > x.i) == (y.i > 0) {
+			return integerNumeric(s)
+		}
+	}
+	if x.isExact() && y.isExact() {
+		return bigNumeric(new(big.Int).Add(x.bigInt(), y.bigInt()))
+	}
+	return floatNumeric(x.float() + y.float())
+}
+
+func negateNumeric(x numeric) numeric {
+	switch x.kind {
+	case numericInteger:
+		if x.i != math.MinInt64 {
+			return integerNumeric(-x.i)
+		}
+		return bigNumeric(new(big.Int).Neg(x.bigInt()))
+	case numericBig:
+		return bigNumeric(new(big.Int).Neg(x.b))
+	}
+	return floatNumeric(-x.f)
+}
+
+func subtractNumeric(x, y numeric) numeric {
+	return addNumeric(x, negateNumeric(y))
+}
+
+func multiplyNumeric(x, y numeric) numeric {
+	if x.kind == numericInteger && y.kind == numericInteger {
+		if x.i == 0 || y.i == 0 {
+			return integerNumeric(0)
+		}
+		p := x.i * y.i
+		if p/y.i == x.i && !(x.i == -1 && y.i == math.MinInt64) && !(y.i == -1 && x.i == math.MinInt64) {
+			return integerNumeric(p)
+		}
+	}
+	if x.isExact() && y.isExact() {
+		return bigNumeric(new(big.Int).Mul(x.bigInt(), y.bigInt()))
+	}
+	return floatNumeric(x.float() * y.float())
+}
+
+// divideNumeric is exact when y divides x, otherwise the result is a float.
+func divideNumeric(x, y numeric) numeric {
+	if x.isExact() && y.isExact() {
+		q, r := new(big.Int).QuoRem(x.bigInt(), y.bigInt(), new(big.Int))
+		if r.Sign() == 0 {
+			return bigNumeric(q)
+		}
+	}
+	return floatNumeric(x.float() / y.float())
+}
+
+// truncateNumeric drops any fractional part, leaving an exact integer
+// unless the float is too large to know its whole part exactly.
+func truncateNumeric(x numeric) numeric {
+	if x.isExact() {
+		return x
+	}
+	return integralNumeric(math.Trunc(x.f))
+}
+
+func intQuotientNumeric(x, y numeric) numeric {
+	x = truncateNumeric(x)
+	y = truncateNumeric(y)
+	if x.kind == numericInteger && y.kind == numericInteger && !(x.i == math.MinInt64 && y.i == -1) {
+		return integerNumeric(x.i / y.i)
+	}
+	if x.isExact() && y.isExact() {
+		return bigNumeric(new(big.Int).Quo(x.bigInt(), y.bigInt()))
+	}
+	return integralNumeric(math.Trunc(x.float() / y.float()))
+}
+
+func remainderNumeric(x, y numeric) numeric {
+	x = truncateNumeric(x)
+	y = truncateNumeric(y)
+	if x.kind == numericInteger && y.kind == numericInteger {
+		if y.i == -1 {
+			return integerNumeric(0)
+		}
+		return integerNumeric(x.i % y.i)
+	}
+	if x.isExact() && y.isExact() {
+		return bigNumeric(new(big.Int).Rem(x.bigInt(), y.bigInt()))
+	}
+	return floatNumeric(math.Mod(x.float(), y.float()))
+}
+
+func powerNumeric(x, y numeric) numeric {
+	if x.isExact() && y.kind == numericInteger && y.i >= 0 {
+		xb := x.bigInt()
+		if int64(xb.BitLen())*y.i <= maxExactPowerBits {
+			return bigNumeric(new(big.Int).Exp(xb, big.NewInt(y.i), nil))
+		}
+	}
+	return floatNumeric(math.Pow(x.float(), y.float()))
+}
+
+func compareNumeric(x, y numeric) int {
+	if x.kind == numericInteger && y.kind == numericInteger {
+		switch {
+		case x.i < y.i:
+			return -1
+		case x.i > y.i:
+			return 1
+		}
+		return 0
+	}
+	if x.isExact() && y.isExact() {
+		return x.bigInt().Cmp(y.bigInt())
+	}
+	xf := x.float()
+	yf := y.float()
+	switch {
+	case xf < yf:
+		return -1
+	case xf > yf:
+		return 1
+	}
+	return 0
+}
+
+func isZeroNumeric(x numeric) bool {
+	switch x.kind {
+	case numericInteger:
+		return x.i == 0
+	case numericBig:
+		return x.b.Sign() == 0
+	}
+	return x.f == 0
+}
+
+func evalToNumeric(node Node) (numeric, error) {
+
+	switch pn := node.(type) {
+	case *WordNode:
+		r, ok := pn.numeric()
+		if !ok {
+			return numeric{}, errorBadInput(node)
+		}
+		return r, nil
+	}
+	return numeric{}, errorNumberExpected(node)
+}
+
+func evalNumericPair(nx, ny Node) (numeric, numeric, error) {
+
+	x, err := evalToNumeric(nx)
+	if err != nil {
+		return numeric{}, numeric{}, err
+	}
+
+	y, err := evalToNumeric(ny)
+	if err != nil {
+		return numeric{}, numeric{}, err
+	}
+
+	return x, y, nil
+}
+
+func createNumberNode(n numeric) Node {
+	wn := newWordNode(-1, -1, n.String(), true)
+	wn.numberState = numberValid
+	wn.num = n
+	return wn
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"io"
+	"strconv"
 	"strings"
 	"unicode"
 )
@@ -11,24 +12,35 @@ const comment rune = ';'
 const escape rune = '\\'
 const listStart rune = '['
 const listEnd rune = ']'
+const arrayStart rune = '{'
+const arrayEnd rune = '}'
+const arrayOrigin rune = '@'
 const groupStart rune = '('
 const groupEnd rune = ')'
 const literalStart rune = '"'
 const newLine rune = '\n'
 const thingStart rune = ':'
 
-var wordSeparators = []rune{' ', '\t', newLine, comment, listEnd, groupEnd}
+var wordSeparators = []rune{' ', '\t', newLine, comment, listEnd, groupEnd, arrayEnd}
 var infixOpChars = []rune{'+', '-', '*', '/', '<', '>', '='}
 var listSeparators = []rune{' ', '\t', newLine, comment}
 
 func ParseString(text string) (n Node, err error) {
+	return ParseStringAt(text, 1)
+}
+
+func ParseStringAt(text string, line int) (n Node, err error) {
 	r := strings.NewReader(text)
-	return Parse(r)
+	return ParseAt(r, line)
 }
 
 func Parse(r io.Reader) (n Node, err error) {
+	return ParseAt(r, 1)
+}
+
+func ParseAt(r io.Reader, line int) (n Node, err error) {
 
-	l := 1
+	l := line
 	c := 1
 	rr := bufio.NewReader(r)
 	var pn Node = nil
@@ -73,6 +85,10 @@ func parse(r *bufio.Reader, line, col *int, inList bool) (n Node, err error) {
 		n, err = readList(r, line, col)
 	case listEnd:
 		r.UnreadRune()
+	case arrayStart:
+		n, err = readArray(r, line, col)
+	case arrayEnd:
+		r.UnreadRune()
 	case groupStart:
 		n, err = readGroup(r, line, col)
 	case groupEnd:
@@ -155,6 +171,46 @@ func readList(r *bufio.Reader, line, col *int) (n Node, err error) {
 	return nil, err
 }
 
+func readArray(r *bufio.Reader, line, col *int) (n Node, err error) {
+	fn, err := readUntil(r, line, col, arrayEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]Node, 0, 4)
+	for c := fn; c != nil; {
+		nc := c.next()
+		c.addNode(nil)
+		items = append(items, c)
+		c = nc
+	}
+
+	origin := 1
+	c, _, err := r.ReadRune()
+	if err == nil && c == arrayOrigin {
+		checkNewline(c, line, col)
+		c, _, err = r.ReadRune()
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		wn, err := readWord(r, line, col)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if wn == nil {
+			return nil, errorNumberExpected(nil)
+		}
+		origin, err = strconv.Atoi(wn.value)
+		if err != nil {
+			return nil, errorBadInput(wn)
+		}
+	} else if err == nil {
+		r.UnreadRune()
+	}
+
+	return newArrayNode(*line, *col, items, origin), nil
+}
+
 func readGroup(r *bufio.Reader, line, col *int) (n Node, err error) {
 	n, err = readUntil(r, line, col, groupEnd)
 
@@ -235,6 +291,7 @@ func readWord(r *bufio.Reader, line, col *int) (*WordNode, error) {
 	chars := make([]rune, 0, 4)
 	escaped := false
 	isLiteral := false
+	quoted := false
 	var pc rune
 	var err error
 	for {
@@ -285,15 +342,20 @@ func readWord(r *bufio.Reader, line, col *int) (*WordNode, error) {
 				escaped = false
 			}
 
-			if len(chars) > 0 || c != literalStart {
+			// Only the first quote is dropped, so ""z is the word "z.
+			if len(chars) > 0 || c != literalStart || quoted {
 				chars = append(chars, c)
 				pc = c
+			} else {
+				quoted = true
 			}
 		}
 	}
 
 	if len(chars) > 0 {
-		return newWordNode(*line, *col, string(chars), isLiteral), err
+		n := newWordNode(*line, *col, string(chars), isLiteral)
+		n.isQuoted = quoted
+		return n, err
 	}
 	return nil, err
 }
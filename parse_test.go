@@ -98,3 +98,19 @@ func TestParseMakeCommand(t *testing.T) {
 	n, err := ParseString("make \"xx (screenwidth / -2)")
 	assert(t, n, err, "make xx ( screenwidth / -2 )")
 }
+
+func TestParseAtLine(t *testing.T) {
+	n, err := ParseStringAt("fd 10\nrt 90", 7)
+	assert(t, n, err, "fd 10 rt 90")
+
+	l, _ := n.next().next().position()
+	if l != 8 {
+		t.Errorf("Expected line 8 was %d", l)
+	}
+}
+
+func TestParseArray(t *testing.T) {
+
+	n, err := ParseString("{a [b c]}@0 d")
+	assert(t, n, err, "{ a [ b c ] }@0 d")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io"
+)
+
+// continueSignal is passed back from CONTINUE to the PAUSE that it ends,
+// in the same way a ThrowError is passed back to CATCH.
+type continueSignal struct {
+	value Node
+}
+
+func (this *continueSignal) Error() string {
+	return "CONTINUE can only be used while paused."
+}
+
+// pause reads and runs lines in the scope of frame until CONTINUE is
+// given or the input runs out. Lines run as inputs would, so they are
+// never taken as tail calls of the paused procedure.
+func pause(frame Frame) *CallResult {
+
+	ws := frame.workspace()
+	fr := ws.files.reader
+
+	prompt := promptPrimary
+	procFrame, _ := findInterpretedFrame(frame)
+	if procFrame != nil {
+		prompt = procFrame.procedure.name + promptPrimary
+	}
+
+	ws.pauseDepth++
+	defer func() {
+		ws.pauseDepth--
+		ws.currentFrame = frame
+	}()
+
+	ws.print("Pausing...\n")
+	for {
+		if fr.IsInteractive() {
+			ws.print(prompt)
+		}
+		line, err := fr.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errorResult(err)
+		}
+		if line == "" {
+			continue
+		}
+
+		n, err := ParseString(line)
+		if err != nil {
+			ws.print(err.Error() + "\n")
+			continue
+		}
+
+		ws.interrupted = false
+		ws.currentFrame = frame
+		enterArgs(frame)
+		rv := evalNodeStream(frame, n, false)
+		leaveArgs(frame)
+
+		if rv != nil && rv.hasError() {
+			cs, ok := rv.err.(*continueSignal)
+			if ok {
+				if cs.value == nil {
+					return nil
+				}
+				return returnResult(cs.value)
+			}
+			ws.print(rv.err.Error() + "\n")
+		}
+		if isFrameStopped(frame) {
+			return stopResult()
+		}
+	}
+}
+
+// errorPause pauses in the procedure where an error happened when ERRPAUSE
+// is on and no CATCH "ERROR is waiting for it. If CONTINUE is given a value
+// the procedure outputs it, otherwise the error is passed on as usual
+// without pausing again in the procedures it passes through.
+func errorPause(frame *InterpretedFrame, rv *CallResult) *CallResult {
+
+	ws := frame.ws
+	_, isLogoError := rv.err.(*LogoError)
+	if !ws.errPause || !isLogoError || ws.errorCatchers > 0 || ws.pausedError == rv.err {
+		return rv
+	}
+	ws.pausedError = rv.err
+
+	ws.print(rv.err.Error() + "\n")
+	pr := pause(frame)
+	if pr != nil && pr.returnValue != nil {
+		return pr
+	}
+	if pr != nil && pr.hasError() {
+		return pr
+	}
+	return rv
+}
+
+// isContinue reports whether proc is CONTINUE, which takes its input from
+// the rest of the line when it is not in parentheses.
+func isContinue(proc Procedure) bool {
+	bp, ok := proc.(*BuiltInProcedure)
+	return ok && bp.name == keywordContinue
+}
+
+func _bi_Pause(frame Frame, parameters []Node) *CallResult {
+
+	return pause(frame)
+}
+
+func _bi_Continue(frame Frame, parameters []Node) *CallResult {
+
+	if frame.workspace().pauseDepth == 0 {
+		return errorResult(errorNotPaused(frame.caller()))
+	}
+	if len(parameters) > 0 {
+		return errorResult(&continueSignal{parameters[0]})
+	}
+	return errorResult(&continueSignal{nil})
+}
+
+func _bi_ErrPause(frame Frame, parameters []Node) *CallResult {
+
+	frame.workspace().errPause = true
+	return nil
+}
+
+func _bi_NoErrPause(frame Frame, parameters []Node) *CallResult {
+
+	frame.workspace().errPause = false
+	return nil
+}
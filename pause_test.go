@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+// usePauseScript makes the test workspace read the lines typed at a pause
+// from a file, returning a function that closes it.
+func usePauseScript(t *testing.T, lines string) func() {
+
+	restore := useTempPrefix(t)
+	err := os.WriteFile(path.Join(ws.files.rootPath, "script.txt"), []byte(lines), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ws.readString("OPEN \"script.txt\nSETREAD \"script.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		ws.readString("SETREAD []\nCLOSE \"script.txt")
+		restore()
+	}
+}
+
+func TestPauseLocals(t *testing.T) {
+
+	err := ws.readString("TO PAUSEFOO :x\nLOCAL \"y\nMAKE \"y 10\nPAUSE\nOUTPUT :x + :y\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer usePauseScript(t, "MAKE \"pauseseen :y\nMAKE \"y :x * 2\nCO\n")()
+	assertExpressionOnce(t, "PAUSEFOO 3", "9")
+	assertExpression(t, ":pauseseen", "10")
+}
+
+func TestContinueValue(t *testing.T) {
+
+	err := ws.readString("TO PAUSEBAR\nOUTPUT PAUSE\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer usePauseScript(t, "CO 7\n(CO 8)\nCONTINUE 4 + 5\n")()
+	assertExpressionOnce(t, "PAUSEBAR", "7")
+	assertExpressionOnce(t, "PAUSEBAR", "8")
+	assertExpressionOnce(t, "PAUSEBAR", "9")
+}
+
+func TestErrPause(t *testing.T) {
+
+	err := ws.readString("TO ERRPAUSEFOO :x\nOUTPUT :x + \"a\nEND")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer usePauseScript(t, "CO :x * 10\n")()
+	err = ws.readString("ERRPAUSE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.readString("NOERRPAUSE")
+	assertExpressionOnce(t, "ERRPAUSEFOO 4", "40")
+}
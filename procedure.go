@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// title returns the TO line of the procedure.
+func (this *InterpretedProcedure) title() string {
+
+	var b bytes.Buffer
+	if this.macro {
+		b.WriteString(keywordMacro + " " + this.name)
+	} else {
+		b.WriteString(keywordTo + " " + this.name)
+	}
+	for _, p := range this.parameters {
+		b.WriteString(" :" + p)
+	}
+	for _, o := range this.optional {
+		b.WriteString(" ")
+		nodeToSource(&b, o.list)
+	}
+	if this.rest != "" {
+		b.WriteString(" [:" + this.rest + "]")
+	}
+	if this.arity != len(this.parameters) {
+		b.WriteString(" " + strconv.Itoa(this.arity))
+	}
+	return b.String()
+}
+
+// nodeToSource writes a node the way it would have to be typed for the
+// parser to read it back with the same meaning.
+func nodeToSource(buf *bytes.Buffer, n Node) {
+
+	switch pn := n.(type) {
+	case *WordNode:
+		if _, isNum := pn.number(); pn.isLiteral && !isNum {
+			buf.WriteRune(literalStart)
+		}
+		buf.WriteString(pn.value)
+
+	case *ListNode:
+		buf.WriteString("[")
+		for nn := pn.firstChild; nn != nil; nn = nn.next() {
+			nodeToSource(buf, nn)
+			if nn.next() != nil {
+				buf.WriteString(" ")
+			}
+		}
+		buf.WriteString("]")
+
+	case *GroupNode:
+		buf.WriteString("(")
+		for nn := pn.firstChild; nn != nil; nn = nn.next() {
+			nodeToSource(buf, nn)
+			if nn.next() != nil {
+				buf.WriteString(" ")
+			}
+		}
+		buf.WriteString(")")
+
+	case *ArrayNode:
+		buf.WriteString(pn.String())
+	}
+}
+
+// startLine returns the line a node begins on. Lists and groups are given
+// the position at which they end, so the line of their first child is used.
+func startLine(n Node) int {
+
+	var c Node
+	switch pn := n.(type) {
+	case *ListNode:
+		c = pn.firstChild
+	case *GroupNode:
+		c = pn.firstChild
+	}
+	if c != nil {
+		return startLine(c)
+	}
+	l, _ := n.position()
+	return l
+}
+
+// procedureText builds the [[inputs] [line] [line]] form of a procedure
+// from its body, one list for each source line.
+func procedureText(p *InterpretedProcedure) Node {
+
+	inputs := make([]Node, 0, len(p.parameters)+len(p.optional)+2)
+	for _, name := range p.parameters {
+		inputs = append(inputs, newWordNode(-1, -1, name, true))
+	}
+	for _, o := range p.optional {
+		def := []Node{newWordNode(-1, -1, o.name, true)}
+		for n := o.list.firstChild.next(); n != nil; n = n.next() {
+			def = append(def, n)
+		}
+		inputs = append(inputs, nodesToList(def))
+	}
+	if p.rest != "" {
+		inputs = append(inputs, nodesToList([]Node{newWordNode(-1, -1, p.rest, true)}))
+	}
+	if p.arity != len(p.parameters) {
+		inputs = append(inputs, createNumericNode(float64(p.arity)))
+	}
+
+	text := []Node{nodesToList(inputs)}
+	line := make([]Node, 0, 8)
+	ln := -1
+	for n := p.firstNode; n != nil; n = n.next() {
+		l := startLine(n)
+		if l != ln && len(line) > 0 {
+			text = append(text, nodesToList(line))
+			line = line[:0]
+		}
+		ln = l
+		line = append(line, n)
+	}
+	if len(line) > 0 {
+		text = append(text, nodesToList(line))
+	}
+
+	return nodesToList(text)
+}
+
+// defineProcedure turns a [[inputs] [line] [line]] list into the source
+// of a procedure and reads it as if it had been typed in after keyword.
+func defineProcedure(ws *Workspace, keyword string, nameNode, textNode Node) error {
+
+	name, err := evalToWord(nameNode)
+	if err != nil {
+		return err
+	}
+	switch ws.findProcedure(strings.ToUpper(name)).(type) {
+	case *BuiltInProcedure:
+		return errorProcIsBuiltIn(nameNode, name)
+	}
+
+	text, ok := textNode.(*ListNode)
+	if !ok || text.firstChild == nil {
+		return errorListExpected(textNode)
+	}
+	inputs, ok := text.firstChild.(*ListNode)
+	if !ok {
+		return errorListExpected(text.firstChild)
+	}
+
+	var b bytes.Buffer
+	b.WriteString(keyword + " " + name)
+	for _, n := range inputs.index() {
+		b.WriteString(" ")
+		switch in := n.(type) {
+		case *WordNode:
+			if _, isNum := in.number(); !isNum {
+				b.WriteString(":" + strings.TrimPrefix(in.value, ":"))
+			} else {
+				b.WriteString(in.value)
+			}
+		case *ListNode:
+			wn, ok := in.firstChild.(*WordNode)
+			if !ok {
+				return errorWordExpected(in)
+			}
+			b.WriteString("[:" + strings.TrimPrefix(wn.value, ":"))
+			for nn := wn.next(); nn != nil; nn = nn.next() {
+				b.WriteString(" ")
+				nodeToSource(&b, nn)
+			}
+			b.WriteString("]")
+		default:
+			return errorWordExpected(n)
+		}
+	}
+	for _, n := range text.index()[1:] {
+		ln, ok := n.(*ListNode)
+		if !ok {
+			return errorListExpected(n)
+		}
+		b.WriteString("\n")
+		for nn := ln.firstChild; nn != nil; nn = nn.next() {
+			nodeToSource(&b, nn)
+			if nn.next() != nil {
+				b.WriteString(" ")
+			}
+		}
+	}
+	b.WriteString("\n" + keywordEnd)
+
+	source := b.String()
+	fn, err := ParseString(source)
+	if err != nil {
+		return err
+	}
+	proc, _, err := readInterpretedProcedure(fn)
+	if err != nil {
+		return err
+	}
+	proc.source = source
+	ws.addProcedure(proc)
+	return nil
+}
+
+func _bi_Define(frame Frame, parameters []Node) *CallResult {
+
+	err := defineProcedure(frame.workspace(), keywordTo, parameters[0], parameters[1])
+	if err != nil {
+		return errorResult(err)
+	}
+	return nil
+}
+
+func _bi_ProcText(frame Frame, parameters []Node) *CallResult {
+
+	name, err := evalToWord(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	switch p := frame.workspace().findProcedure(strings.ToUpper(name)).(type) {
+	case *InterpretedProcedure:
+		return returnResult(procedureText(p))
+	case *BuiltInProcedure:
+		return errorResult(errorProcIsBuiltIn(parameters[0], name))
+	}
+	return errorResult(errorProcedureNotFound(parameters[0], name))
+}
+
+func _bi_CopyDef(frame Frame, parameters []Node) *CallResult {
+
+	newName, err := evalToWord(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+	oldName, err := evalToWord(parameters[1])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	ws := frame.workspace()
+	newName = strings.ToUpper(newName)
+	switch ws.findProcedure(newName).(type) {
+	case *BuiltInProcedure:
+		return errorResult(errorProcIsBuiltIn(parameters[0], newName))
+	}
+
+	switch p := ws.findProcedure(strings.ToUpper(oldName)).(type) {
+	case *InterpretedProcedure:
+		np := &InterpretedProcedure{newName, p.parameters, p.firstNode, "", false, false, nil, p.optional, p.rest, p.arity, p.macro, p.firstLine, nil}
+		np.source = np.title()
+		ix := strings.Index(p.source, "\n")
+		if ix >= 0 {
+			np.source += p.source[ix:]
+		}
+		ws.addProcedure(np)
+	case *BuiltInProcedure:
+		ws.procedures[newName] = p
+		ws.invalidateCode()
+	default:
+		return errorResult(errorProcedureNotFound(parameters[1], oldName))
+	}
+	return nil
+}
+
+func procedureIs(frame Frame, nameNode Node, test func(p Procedure) bool) *CallResult {
+
+	name, err := evalToWord(nameNode)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	p := frame.workspace().findProcedure(strings.ToUpper(name))
+	if p != nil && test(p) {
+		return returnResult(trueNode)
+	}
+	return returnResult(falseNode)
+}
+
+func _bi_Definedp(frame Frame, parameters []Node) *CallResult {
+
+	return procedureIs(frame, parameters[0], func(p Procedure) bool {
+		_, ok := p.(*InterpretedProcedure)
+		return ok
+	})
+}
+
+func _bi_Primitivep(frame Frame, parameters []Node) *CallResult {
+
+	return procedureIs(frame, parameters[0], func(p Procedure) bool {
+		_, ok := p.(*BuiltInProcedure)
+		return ok
+	})
+}
+
+func _bi_Arity(frame Frame, parameters []Node) *CallResult {
+
+	name, err := evalToWord(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	var min, def, max int
+	switch p := frame.workspace().findProcedure(strings.ToUpper(name)).(type) {
+	case *InterpretedProcedure:
+		min, def, max = len(p.parameters), p.arity, p.maxParameters()
+	case *BuiltInProcedure:
+		min, def, max = p.paramCount, p.paramCount, p.paramCount
+		if p.allowVarParams {
+			max = -1
+		}
+	default:
+		return errorResult(errorProcedureNotFound(parameters[0], name))
+	}
+
+	return returnResult(nodesToList([]Node{
+		createNumericNode(float64(min)),
+		createNumericNode(float64(def)),
+		createNumericNode(float64(max))}))
+}
+
+func namesToList(names []string) Node {
+
+	sort.Strings(names)
+	nodes := make([]Node, 0, len(names))
+	for _, n := range names {
+		nodes = append(nodes, newWordNode(-1, -1, n, true))
+	}
+	return nodesToList(nodes)
+}
+
+func procedureNames(ws *Workspace) Node {
+
+	names := make([]string, 0, len(ws.procedures))
+	for n, p := range ws.procedures {
+		ip, ok := p.(*InterpretedProcedure)
+		if ok && !ip.buried {
+			names = append(names, n)
+		}
+	}
+	return namesToList(names)
+}
+
+func variableNames(ws *Workspace, withProps bool) Node {
+
+	vs := ws.rootFrame.getVars().vars
+	names := make([]string, 0, len(vs))
+	for n, v := range vs {
+		if v.buried {
+			continue
+		}
+		if (withProps && v.hasProps()) || (!withProps && v.value != nil) {
+			names = append(names, n)
+		}
+	}
+	return namesToList(names)
+}
+
+func _bi_Procedures(frame Frame, parameters []Node) *CallResult {
+
+	return returnResult(procedureNames(frame.workspace()))
+}
+
+func _bi_Primitives(frame Frame, parameters []Node) *CallResult {
+
+	ws := frame.workspace()
+	names := make([]string, 0, len(ws.procedures))
+	for n, p := range ws.procedures {
+		if _, ok := p.(*BuiltInProcedure); ok {
+			names = append(names, n)
+		}
+	}
+	return returnResult(namesToList(names))
+}
+
+func _bi_Names(frame Frame, parameters []Node) *CallResult {
+
+	return returnResult(nodesToList([]Node{
+		newListNode(-1, -1, nil),
+		variableNames(frame.workspace(), false)}))
+}
+
+func _bi_Contents(frame Frame, parameters []Node) *CallResult {
+
+	ws := frame.workspace()
+	return returnResult(nodesToList([]Node{
+		procedureNames(ws),
+		variableNames(ws, false),
+		variableNames(ws, true)}))
+}
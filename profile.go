@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+type profileEntry struct {
+	name   string
+	calls  int
+	total  time.Duration
+	self   time.Duration
+	active int
+}
+
+type profileCall struct {
+	entry    *profileEntry
+	start    time.Time
+	children time.Duration
+}
+
+// A profiler counts the calls made to each procedure and the time spent in
+// them. Total time includes the procedures called, self time does not; the
+// total of a recursive procedure is only taken at its outermost call.
+type profiler struct {
+	entries map[string]*profileEntry
+	stack   []profileCall
+	running bool
+}
+
+func newProfiler() *profiler {
+	return &profiler{make(map[string]*profileEntry), make([]profileCall, 0, 64), true}
+}
+
+// profiling returns the profiler when PROFILE is on, otherwise nil.
+func (this *Workspace) profiling() *profiler {
+	if this.profile == nil || !this.profile.running {
+		return nil
+	}
+	return this.profile
+}
+
+func (this *profiler) enter(name string) {
+
+	e, ok := this.entries[name]
+	if !ok {
+		e = &profileEntry{name: name}
+		this.entries[name] = e
+	}
+	e.calls++
+	e.active++
+	this.stack = append(this.stack, profileCall{e, time.Now(), 0})
+}
+
+func (this *profiler) exit() {
+
+	if len(this.stack) == 0 {
+		return
+	}
+	c := this.stack[len(this.stack)-1]
+	this.stack = this.stack[:len(this.stack)-1]
+
+	elapsed := time.Since(c.start)
+	c.entry.active--
+	if c.entry.active == 0 {
+		c.entry.total += elapsed
+	}
+	c.entry.self += elapsed - c.children
+	if len(this.stack) > 0 {
+		this.stack[len(this.stack)-1].children += elapsed
+	}
+}
+
+// report lists the procedures called, those with the most self time first.
+func (this *profiler) report() string {
+
+	entries := make([]*profileEntry, 0, len(this.entries))
+	for _, e := range this.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].self != entries[j].self {
+			return entries[i].self > entries[j].self
+		}
+		return entries[i].name < entries[j].name
+	})
+
+	var b bytes.Buffer
+	b.WriteString(fmt.Sprintf("%-20s %10s %12s %12s\n", "PROCEDURE", "CALLS", "TOTAL MS", "SELF MS"))
+	for _, e := range entries {
+		b.WriteString(fmt.Sprintf("%-20s %10d %12.3f %12.3f\n", e.name, e.calls,
+			float64(e.total)/float64(time.Millisecond),
+			float64(e.self)/float64(time.Millisecond)))
+	}
+	return b.String()
+}
+
+func _bi_Profile(frame Frame, parameters []Node) *CallResult {
+
+	frame.workspace().profile = newProfiler()
+	return nil
+}
+
+func _bi_NoProfile(frame Frame, parameters []Node) *CallResult {
+
+	ws := frame.workspace()
+	if ws.profile != nil {
+		ws.profile.running = false
+	}
+	return nil
+}
+
+func _bi_ProfileReport(frame Frame, parameters []Node) *CallResult {
+
+	ws := frame.workspace()
+	if ws.profile == nil {
+		return errorResult(errorNoProfile(frame.caller()))
+	}
+	report := ws.profile.report()
+
+	if len(parameters) == 0 {
+		ws.print(report)
+		return nil
+	}
+
+	name, err := evalToWord(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+	p, err := ws.files.normPath(name)
+	if err != nil {
+		return errorResult(err)
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return errorResult(err)
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(report)
+	if err != nil {
+		return errorResult(err)
+	}
+	return nil
+}
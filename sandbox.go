@@ -0,0 +1,131 @@
+package main
+
+import (
+	"time"
+)
+
+// The clock is only read every sandboxClockInterval calls, as reading it
+// costs more than the call itself for many primitives.
+const sandboxClockInterval = 256
+
+// A Sandbox limits what a program may do, for running code that is not
+// trusted. Limits are counted from when the sandbox is set and a limit of
+// zero is not enforced.
+type Sandbox struct {
+	MaxInstructions int64
+	MaxTime         time.Duration
+	MaxValueSize    int64
+	RestrictFiles   bool
+}
+
+type sandboxState struct {
+	limits       Sandbox
+	instructions int64
+	start        time.Time
+	timedOut     bool
+}
+
+// SetSandbox applies limits to everything the workspace runs from now on.
+// Restricting files confines them to the current prefix directory.
+func (this *Workspace) SetSandbox(s Sandbox) {
+
+	this.sandbox = &sandboxState{s, 0, time.Now(), false}
+	if s.RestrictFiles {
+		this.files.jail = this.files.rootPath
+		if rp, err := resolvePath(this.files.rootPath); err == nil {
+			this.files.jail = rp
+		}
+	}
+}
+
+// step counts a procedure call against the limits. Once a limit has been
+// exceeded every later call fails too, so catching the error does not let
+// a program carry on.
+func (this *sandboxState) step(caller *WordNode) error {
+
+	this.instructions++
+	l := &this.limits
+	if l.MaxInstructions > 0 && this.instructions > l.MaxInstructions {
+		return sandboxError(caller, "instruction")
+	}
+	if l.MaxTime > 0 && !this.timedOut && this.instructions%sandboxClockInterval == 0 {
+		this.timedOut = time.Since(this.start) > l.MaxTime
+	}
+	if this.timedOut {
+		return sandboxError(caller, "time")
+	}
+	return nil
+}
+
+// output fails when a primitive outputs a word longer than the limit, in
+// bytes, or a list or array with more items. Together with the instruction
+// limit this bounds the memory a program can take.
+func (this *sandboxState) output(caller *WordNode, rv *CallResult) *CallResult {
+
+	max := this.limits.MaxValueSize
+	if max == 0 || rv == nil || rv.returnValue == nil {
+		return rv
+	}
+	var size int64
+	switch n := rv.returnValue.(type) {
+	case *WordNode:
+		size = int64(len(n.value))
+	case *ListNode:
+		size = int64(n.length())
+	case *ArrayNode:
+		size = int64(len(n.array.items))
+	}
+	if size > max {
+		return errorResult(sandboxError(caller, "value size"))
+	}
+	return rv
+}
+
+// checkValueSize is for primitives that make large values, so that they
+// can fail before the memory is taken.
+func checkValueSize(frame Frame, size int64) error {
+
+	sb := frame.workspace().sandbox
+	if sb != nil && sb.limits.MaxValueSize > 0 && size > sb.limits.MaxValueSize {
+		return sandboxError(frame.caller(), "value size")
+	}
+	return nil
+}
+
+// sandboxError reports a limit exceeded at the call of caller, which is nil
+// at the top level.
+func sandboxError(caller *WordNode, limit string) error {
+
+	var n Node
+	if caller != nil {
+		n = caller
+	}
+	return errorSandboxLimit(n, limit)
+}
+
+// checkSandbox counts a pass of a loop against the limits, so that a loop
+// whose body calls nothing, such as FOREVER [], is still stopped.
+func checkSandbox(frame Frame) *CallResult {
+
+	sb := frame.workspace().sandbox
+	if sb == nil {
+		return nil
+	}
+	if err := sb.step(frame.caller()); err != nil {
+		return errorResult(err)
+	}
+	return nil
+}
+
+// wait sleeps for d unless that would go past the time limit, in which case
+// it fails at once rather than holding on to the time left.
+func (this *sandboxState) wait(d time.Duration, caller *WordNode) error {
+
+	l := &this.limits
+	if l.MaxTime > 0 && (this.timedOut || time.Since(this.start)+d > l.MaxTime) {
+		this.timedOut = true
+		return sandboxError(caller, "time")
+	}
+	time.Sleep(d)
+	return nil
+}
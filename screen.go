@@ -19,9 +19,14 @@ type Screen struct {
 	channel    *Channel
 }
 
-func initScreen(workspace *Workspace, w, h int) *Screen {
+func initScreen(workspace *Workspace, w, h int, headless bool) *Screen {
 
-	ss := newWindow(workspace.broker, w, h)
+	var ss Window
+	if headless {
+		ss = newMemWindow(workspace.broker, w, h)
+	} else {
+		ss = newWindow(workspace.broker, w, h)
+	}
 	w = ss.W()
 	h = ss.H()
 
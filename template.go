@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	templateRest  = "?REST"
+	templateCount = "#"
+)
+
+func templateSlotName(ix int) string {
+	return "?" + fmt.Sprint(ix)
+}
+
+func setTemplateVar(frame Frame, name string, value Node) {
+	frame.getVars().createLocal(name)
+	frame.getVars().setVariable(frame, name, value)
+}
+
+func applyTemplate(frame Frame, template Node, args []Node) *CallResult {
+
+	switch t := template.(type) {
+	case *WordNode:
+		proc := frame.workspace().findProcedure(strings.ToUpper(t.value))
+		if proc == nil {
+			return errorResult(errorProcedureNotFound(t, t.value))
+		}
+		if len(args) < proc.parameterCount() {
+			return errorResult(errorNotEnoughParameters(t, t))
+		}
+		if len(args) > proc.parameterCount() && !proc.allowVarParameters() {
+			return errorResult(errorTooManyInputs(t, t.value))
+		}
+		return callProcedureWithParams(frame, t, args...)
+
+	case *ListNode:
+		body := t.firstChild
+		names, ok := body.(*ListNode)
+		if ok {
+			ix := 0
+			for n := names.firstChild; n != nil; n = n.next() {
+				wn, ok := n.(*WordNode)
+				if !ok {
+					return errorResult(errorWordExpected(n))
+				}
+				if ix >= len(args) {
+					return errorResult(errorNotEnoughParameters(frame.caller(), t))
+				}
+				setTemplateVar(frame, strings.TrimPrefix(wn.value, ":"), args[ix])
+				ix++
+			}
+			body = names.next()
+		} else {
+			for ix, a := range args {
+				setTemplateVar(frame, templateSlotName(ix+1), a)
+			}
+		}
+		return evalNodeStream(frame, body, true)
+	}
+
+	return errorResult(errorBadInput(template))
+}
+
+func templateSlot(name string) evaluator {
+	return func(frame Frame, parameters []Node) *CallResult {
+		v := frame.getVars().getVariable(frame, name)
+		if v == nil {
+			return errorResult(errorVariableNotFound(frame.caller(), frame.caller().value))
+		}
+		return returnResult(v)
+	}
+}
+
+type templateData struct {
+	items  []Node
+	isWord bool
+}
+
+func readTemplateData(node Node) (*templateData, error) {
+
+	switch n := node.(type) {
+	case *WordNode:
+		d := &templateData{make([]Node, 0, len(n.value)), true}
+		for _, c := range n.value {
+			d.items = append(d.items, newWordNode(-1, -1, string(c), true))
+		}
+		return d, nil
+	case *ListNode:
+		d := &templateData{make([]Node, 0, n.length()), false}
+		for c := n.firstChild; c != nil; c = c.next() {
+			item := c.clone()
+			item.setLiteral()
+			d.items = append(d.items, item)
+		}
+		return d, nil
+	}
+	return nil, errorBadInput(node)
+}
+
+func (this *templateData) rest(ix int) Node {
+
+	if this.isWord {
+		s := ""
+		for _, c := range this.items[ix+1:] {
+			s += c.String()
+		}
+		return newWordNode(-1, -1, s, true)
+	}
+	return nodesToList(this.items[ix+1:])
+}
+
+func nodesToWord(nodes []Node) (Node, error) {
+
+	s := ""
+	for _, n := range nodes {
+		wn, ok := n.(*WordNode)
+		if !ok {
+			return nil, errorWordExpected(n)
+		}
+		s += wn.value
+	}
+	return newWordNode(-1, -1, s, true), nil
+}
+
+func readTemplateInputs(parameters []Node) ([]*templateData, int, error) {
+
+	data := make([]*templateData, 0, len(parameters))
+	l := -1
+	for _, p := range parameters {
+		d, err := readTemplateData(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		if l >= 0 && len(d.items) != l {
+			return nil, 0, errorBadInput(p)
+		}
+		l = len(d.items)
+		data = append(data, d)
+	}
+	return data, l, nil
+}
+
+func iterateTemplate(frame Frame, template Node, data []*templateData, l int, f func(ix int, rv *CallResult) (bool, error)) *CallResult {
+
+	for ix := 0; ix < l; ix++ {
+		args := make([]Node, len(data))
+		for dx, d := range data {
+			args[dx] = d.items[ix]
+		}
+
+		setTemplateVar(frame, templateRest, data[0].rest(ix))
+		setTemplateVar(frame, templateCount, createNumericNode(float64(ix+1)))
+
+		rv := applyTemplate(frame, template, args)
+		if rv != nil && rv.hasError() {
+			return rv
+		}
+		if isFrameStopped(frame) {
+			return stopResult()
+		}
+		if frame.workspace().interrupted {
+			return errorResult(errorUserStopped(frame.caller()))
+		}
+
+		done, err := f(ix, rv)
+		if err != nil {
+			return errorResult(err)
+		}
+		if done {
+			break
+		}
+	}
+	return nil
+}
+
+func templateValue(frame Frame, rv *CallResult) (Node, error) {
+	if rv == nil || rv.returnValue == nil {
+		return nil, errorNoOutput(frame.caller())
+	}
+	return rv.returnValue, nil
+}
+
+func _bi_Apply(frame Frame, parameters []Node) *CallResult {
+
+	d, err := readTemplateData(parameters[1])
+	if err != nil {
+		return errorResult(err)
+	}
+	if d.isWord {
+		return errorResult(errorListExpected(parameters[1]))
+	}
+
+	return applyTemplate(frame, parameters[0], d.items)
+}
+
+func _bi_Invoke(frame Frame, parameters []Node) *CallResult {
+
+	return applyTemplate(frame, parameters[0], parameters[1:])
+}
+
+func _bi_Foreach(frame Frame, parameters []Node) *CallResult {
+
+	l := len(parameters) - 1
+	data, n, err := readTemplateInputs(parameters[:l])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	return iterateTemplate(frame, parameters[l], data, n, func(ix int, rv *CallResult) (bool, error) {
+		return false, nil
+	})
+}
+
+func _bi_Map(frame Frame, parameters []Node) *CallResult {
+
+	data, n, err := readTemplateInputs(parameters[1:])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	res := make([]Node, 0, n)
+	rv := iterateTemplate(frame, parameters[0], data, n, func(ix int, rv *CallResult) (bool, error) {
+		v, err := templateValue(frame, rv)
+		if err != nil {
+			return true, err
+		}
+		res = append(res, v)
+		return false, nil
+	})
+	if rv != nil {
+		return rv
+	}
+
+	if data[0].isWord {
+		w, err := nodesToWord(res)
+		if err != nil {
+			return errorResult(err)
+		}
+		return returnResult(w)
+	}
+	return returnResult(nodesToList(res))
+}
+
+func _bi_Filter(frame Frame, parameters []Node) *CallResult {
+
+	data, n, err := readTemplateInputs(parameters[1:2])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	res := make([]Node, 0, n)
+	rv := iterateTemplate(frame, parameters[0], data, n, func(ix int, rv *CallResult) (bool, error) {
+		v, err := templateValue(frame, rv)
+		if err != nil {
+			return true, err
+		}
+		b, err := evalToBoolean(v)
+		if err != nil {
+			return true, err
+		}
+		if b {
+			res = append(res, data[0].items[ix])
+		}
+		return false, nil
+	})
+	if rv != nil {
+		return rv
+	}
+
+	if data[0].isWord {
+		w, _ := nodesToWord(res)
+		return returnResult(w)
+	}
+	return returnResult(nodesToList(res))
+}
+
+func _bi_Find(frame Frame, parameters []Node) *CallResult {
+
+	data, n, err := readTemplateInputs(parameters[1:2])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	var found Node
+	rv := iterateTemplate(frame, parameters[0], data, n, func(ix int, rv *CallResult) (bool, error) {
+		v, err := templateValue(frame, rv)
+		if err != nil {
+			return true, err
+		}
+		b, err := evalToBoolean(v)
+		if err != nil {
+			return true, err
+		}
+		if b {
+			found = data[0].items[ix]
+		}
+		return b, nil
+	})
+	if rv != nil {
+		return rv
+	}
+
+	if found == nil {
+		return returnResult(newListNode(-1, -1, nil))
+	}
+	return returnResult(found)
+}
+
+func _bi_Reduce(frame Frame, parameters []Node) *CallResult {
+
+	d, err := readTemplateData(parameters[1])
+	if err != nil {
+		return errorResult(err)
+	}
+	if len(d.items) == 0 {
+		return errorResult(errorBadInput(parameters[1]))
+	}
+
+	acc := d.items[len(d.items)-1]
+	for ix := len(d.items) - 2; ix >= 0; ix-- {
+		rv := applyTemplate(frame, parameters[0], []Node{d.items[ix], acc})
+		if rv != nil && rv.hasError() {
+			return rv
+		}
+		if isFrameStopped(frame) {
+			return stopResult()
+		}
+		acc, err = templateValue(frame, rv)
+		if err != nil {
+			return errorResult(err)
+		}
+	}
+
+	return returnResult(acc)
+}
+
+func _bi_Crossmap(frame Frame, parameters []Node) *CallResult {
+
+	inputs := parameters[1:]
+	if len(inputs) == 1 {
+		ln, ok := inputs[0].(*ListNode)
+		if !ok {
+			return errorResult(errorListExpected(inputs[0]))
+		}
+		inputs = make([]Node, 0, ln.length())
+		for n := ln.firstChild; n != nil; n = n.next() {
+			inputs = append(inputs, n)
+		}
+	}
+
+	data := make([]*templateData, 0, len(inputs))
+	for _, p := range inputs {
+		d, err := readTemplateData(p)
+		if err != nil {
+			return errorResult(err)
+		}
+		data = append(data, d)
+	}
+
+	res := make([]Node, 0)
+	args := make([]Node, len(data))
+	var cross func(dx int) *CallResult
+	cross = func(dx int) *CallResult {
+		if dx == len(data) {
+			rv := applyTemplate(frame, parameters[0], append([]Node(nil), args...))
+			if rv != nil && rv.hasError() {
+				return rv
+			}
+			if isFrameStopped(frame) {
+				return stopResult()
+			}
+			v, err := templateValue(frame, rv)
+			if err != nil {
+				return errorResult(err)
+			}
+			res = append(res, v)
+			return nil
+		}
+		for _, item := range data[dx].items {
+			args[dx] = item
+			rv := cross(dx + 1)
+			if rv != nil {
+				return rv
+			}
+		}
+		return nil
+	}
+
+	rv := cross(0)
+	if rv != nil {
+		return rv
+	}
+
+	return returnResult(nodesToList(res))
+}
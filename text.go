@@ -141,6 +141,7 @@ func (this *ConsoleScreen) ReadLine() (string, error) {
 					this.clearEditLine()
 					this.Write(line)
 					this.Write("\n")
+					this.ws.files.writeDribble(line + "\n")
 					this.channel.Pause()
 					return line, nil
 				case K_LEFT:
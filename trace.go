@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+func (this *Workspace) isTraced(name string) bool {
+	return len(this.traced) > 0 && this.traced[name]
+}
+
+// traceText writes a value the way it is shown in trace output: words that
+// are not numbers are quoted at the top level, lists keep their brackets.
+func traceText(buf *bytes.Buffer, n Node, top bool) {
+
+	switch pn := n.(type) {
+	case *WordNode:
+		if _, isNum := pn.number(); top && !isNum {
+			buf.WriteRune(literalStart)
+		}
+		buf.WriteString(pn.value)
+
+	case *ListNode:
+		buf.WriteString("[")
+		for nn := pn.firstChild; nn != nil; nn = nn.next() {
+			traceText(buf, nn, false)
+			if nn.next() != nil {
+				buf.WriteString(" ")
+			}
+		}
+		buf.WriteString("]")
+
+	default:
+		nodeToText(buf, n, true)
+	}
+}
+
+// traceCall prints a call to a traced procedure with the values of its
+// inputs, indented by the depth of the call.
+func traceCall(ws *Workspace, depth int, name string, parameters []Node) {
+
+	var b bytes.Buffer
+	b.WriteString(strings.Repeat(" ", depth-1))
+	b.WriteString("( " + name)
+	for _, p := range parameters {
+		b.WriteString(" ")
+		traceText(&b, p, true)
+	}
+	b.WriteString(" )\n")
+	ws.print(b.String())
+}
+
+// traceExit prints what a traced procedure output, or that it stopped.
+func traceExit(ws *Workspace, depth int, name string, rv *CallResult) {
+
+	var b bytes.Buffer
+	b.WriteString(strings.Repeat(" ", depth-1))
+	b.WriteString(name)
+	if rv != nil && rv.returnValue != nil {
+		b.WriteString(" outputs ")
+		traceText(&b, rv.returnValue, true)
+	} else {
+		b.WriteString(" stops")
+	}
+	b.WriteString("\n")
+	ws.print(b.String())
+}
+
+// traceMake prints the new value of a traced variable and the procedure
+// that set it.
+func traceMake(frame Frame, v *Variable) {
+
+	var b bytes.Buffer
+	b.WriteString("Make \"" + v.name + " ")
+	traceText(&b, v.value, true)
+	procFrame, _ := findInterpretedFrame(frame)
+	if procFrame != nil {
+		b.WriteString(" in " + procFrame.procedure.name)
+	}
+	b.WriteString("\n")
+	frame.workspace().print(b.String())
+}
+
+// traceNames reads the input to TRACE and UNTRACE, which is a procedure
+// name, a list of procedure names or a contents list of the form
+// [[procedures] [variables]].
+func traceNames(ws *Workspace, node Node) ([]string, []string, error) {
+
+	procNode, varNode := node, Node(nil)
+	if ln, ok := node.(*ListNode); ok {
+		if _, isList := ln.firstChild.(*ListNode); isList {
+			procNode = ln.firstChild
+			varNode = ln.firstChild.next()
+		}
+	}
+
+	procs, err := toWordList(procNode)
+	if err != nil {
+		return nil, nil, err
+	}
+	procNames := make([]string, 0, len(procs))
+	for _, n := range procs {
+		name := strings.ToUpper(n.value)
+		if bp, ok := ws.findProcedure(name).(*BuiltInProcedure); ok {
+			name = bp.name
+		}
+		procNames = append(procNames, name)
+	}
+
+	var varNames []string
+	if varNode != nil {
+		vars, err := toWordList(varNode)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, n := range vars {
+			varNames = append(varNames, strings.ToUpper(n.value))
+		}
+	}
+	return procNames, varNames, nil
+}
+
+func _bi_Trace(frame Frame, parameters []Node) *CallResult {
+
+	ws := frame.workspace()
+	procs, vars, err := traceNames(ws, parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+	for _, n := range procs {
+		ws.traced[n] = true
+	}
+	for _, n := range vars {
+		ws.tracedVars[n] = true
+	}
+
+	return nil
+}
+
+func _bi_Untrace(frame Frame, parameters []Node) *CallResult {
+
+	ws := frame.workspace()
+	procs, vars, err := traceNames(ws, parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+	for _, n := range procs {
+		delete(ws.traced, n)
+	}
+	for _, n := range vars {
+		delete(ws.tracedVars, n)
+	}
+
+	return nil
+}
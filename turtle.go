@@ -3,8 +3,11 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"image"
 	"image/color"
+	"image/png"
 	"math"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -84,17 +87,20 @@ type Turtle struct {
 	mutex        *sync.Mutex
 	visW         int
 	visH         int
+	displayList  []*displayOp
 }
 
 func initTurtle(ws *Workspace) *Turtle {
 	turtle := &Turtle{
 		0, 0, 0, -1, 1.0, turtleStateShown, penStateDown, borderModeWindow,
-		colorWhite, colorBlack, colorWhite, ws, nil, nil, nil, nil, &sync.Mutex{}, 0, 0}
+		colorWhite, colorBlack, colorWhite, ws, nil, nil, nil, nil, &sync.Mutex{}, 0, 0, nil}
 
 	turtle.sprite = ws.screen.screen.CreateSurface(turtleSize*2, turtleSize*2, true)
 	turtle.image = ws.screen.screen.CreateSurface(ws.screen.screen.W(), ws.screen.screen.H(), true)
 	turtle.channel = ws.broker.Subscribe("Turtle", MT_VisibleAreaChange)
 	turtle.dirtyRegions = make([]*Region, 0, 16)
+	turtle.displayList = make([]*displayOp, 0, 64)
+	turtle.resetDisplayList()
 
 	ws.registerBuiltIn("FORWARD", "FD", 1, _t_Forward)
 	ws.registerBuiltIn("BACK", "BK", 1, _t_Back)
@@ -139,6 +145,10 @@ func initTurtle(ws *Workspace) *Turtle {
 	ws.registerBuiltIn("SCREENWIDTH", "", 0, _t_ScreenWidth)
 	ws.registerBuiltIn("SCREENHEIGHT", "", 0, _t_ScreenHeight)
 
+	ws.registerBuiltInWithVarParams("SAVEPIC", "", 1, _t_SavePic)
+	ws.registerBuiltInWithVarParams("LOADPIC", "", 1, _t_LoadPic)
+	ws.registerBuiltInWithVarParams("SAVESVG", "", 1, _t_SaveSvg)
+
 	go turtle.listen()
 	go turtle.tick()
 
@@ -164,6 +174,7 @@ func (this *Turtle) denormY(y int) int {
 func (this *Turtle) clear() {
 	this.image.SetColor(this.screenColor)
 	this.image.Clear()
+	this.resetDisplayList()
 
 	this.invalidate()
 }
@@ -279,6 +290,7 @@ func (this *Turtle) drawLine(x1, y1, x2, y2 int) (int, int) {
 					goto done
 				case borderModeWrap:
 
+					this.recordLine(rx1, ry1, x1, y1)
 					this.addDirtyRegion(rx1, ry1, x1, y1)
 
 					tx := x1 - x2
@@ -308,6 +320,7 @@ func (this *Turtle) drawLine(x1, y1, x2, y2 int) (int, int) {
 					goto done
 				case borderModeWrap:
 
+					this.recordLine(rx1, ry1, x1, y1)
 					this.addDirtyRegion(rx1, ry1, x1, y1)
 
 					ty := y1 - y2
@@ -329,6 +342,7 @@ func (this *Turtle) drawLine(x1, y1, x2, y2 int) (int, int) {
 done:
 
 	if this.penState != penStateUp {
+		this.recordLine(rx1, ry1, x1, y1)
 		this.addDirtyRegion(rx1, ry1, x2, y2)
 	} else {
 		this.addDirtyRegion(rx1, ry1, rx1, ry1)
@@ -344,7 +358,12 @@ func (this *Turtle) fill() {
 	y := this.normY(int(this.y))
 
 	this.image.SetColor(this.penColor)
-	x1, y1, x2, y2 := this.image.Flood(x, y)
+	runs := this.image.Flood(x, y)
+	if len(runs) == 0 {
+		return
+	}
+	this.record(&displayOp{op: displayOpFill, runs: runs})
+	x1, y1, x2, y2 := runBounds(runs)
 	this.addDirtyRegion(x1, y1, x2, y2+1)
 }
 
@@ -704,6 +723,7 @@ func _t_Text(frame Frame, parameters []Node) *CallResult {
 		nx = gm.renderGlyph(c, glyphStyleNormal, t.image, nx, ny)
 	}
 
+	t.record(&displayOp{op: displayOpText, x1: x1, y1: ny, x2: nx, y2: ny + gm.charHeight, text: text})
 	t.addDirtyRegion(x1, ny, nx, ny+gm.charHeight)
 
 	return nil
@@ -728,7 +748,9 @@ func _t_SetPc(frame Frame, parameters []Node) *CallResult {
 	if err != nil {
 		return errorResult(err)
 	}
-	frame.workspace().turtle.penColor = c
+	t := frame.workspace().turtle
+	t.penColor = c
+	t.record(&displayOp{op: displayOpPenColor, c: c})
 	return nil
 }
 
@@ -738,8 +760,10 @@ func _t_SetBg(frame Frame, parameters []Node) *CallResult {
 	if err != nil {
 		return errorResult(err)
 	}
-	frame.workspace().turtle.screenColor = c
-	frame.workspace().turtle.invalidate()
+	t := frame.workspace().turtle
+	t.screenColor = c
+	t.record(&displayOp{op: displayOpBackground, c: c})
+	t.invalidate()
 
 	return nil
 }
@@ -921,3 +945,147 @@ func _t_ScreenWidth(frame Frame, parameters []Node) *CallResult {
 func _t_ScreenHeight(frame Frame, parameters []Node) *CallResult {
 	return returnResult(createNumericNode(float64(frame.workspace().turtle.visH)))
 }
+
+func (this *Turtle) toImage(cropToVisible bool) *image.RGBA {
+
+	w := this.image.W()
+	h := this.image.H()
+	if cropToVisible && this.visW > 0 && this.visH > 0 {
+		w = intMin(w, this.visW)
+		h = intMin(h, this.visH)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, a := this.image.ColorAt(x, y).RGBA()
+			if a == 0 {
+				img.SetRGBA(x, y, this.screenColor)
+			} else {
+				img.SetRGBA(x, y, color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 0xff})
+			}
+		}
+	}
+
+	return img
+}
+
+func (this *Turtle) drawImage(img image.Image, x, y int, scale float64) {
+
+	b := img.Bounds()
+	w := int(float64(b.Dx()) * scale)
+	h := int(float64(b.Dy()) * scale)
+
+	for dy := 0; dy < h; dy++ {
+		sy := b.Min.Y + int(float64(dy)/scale)
+		for dx := 0; dx < w; dx++ {
+			sx := b.Min.X + int(float64(dx)/scale)
+			c := color.RGBAModel.Convert(img.At(sx, sy)).(color.RGBA)
+			if c.A == 0 {
+				continue
+			}
+			c.A = 0xff
+			this.image.SetColor(c)
+			this.image.DrawPoint(x+dx, y+dy)
+		}
+	}
+
+	this.record(&displayOp{op: displayOpImage, x1: x, y1: y, x2: x + w, y2: y + h, img: img})
+	this.addDirtyRegion(x, y, x+w, y+h)
+}
+
+func _t_SavePic(frame Frame, parameters []Node) *CallResult {
+
+	name, err := evalToWord(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	crop := false
+	if len(parameters) > 1 {
+		crop, err = evalToBoolean(parameters[1])
+		if err != nil {
+			return errorResult(err)
+		}
+	}
+
+	ws := frame.workspace()
+	p, err := ws.files.normPath(name)
+	if err != nil {
+		return errorResult(err)
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return errorResult(err)
+	}
+	defer f.Close()
+
+	err = png.Encode(f, ws.turtle.toImage(crop))
+	if err != nil {
+		return errorResult(err)
+	}
+
+	return nil
+}
+
+func _t_LoadPic(frame Frame, parameters []Node) *CallResult {
+
+	name, err := evalToWord(parameters[0])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	ws := frame.workspace()
+	t := ws.turtle
+	x := 0
+	y := 0
+	scale := 1.0
+	if len(parameters) > 1 {
+		switch p := parameters[1].(type) {
+		case *WordNode:
+			scale, err = evalToNumber(p)
+			if err != nil {
+				return errorResult(err)
+			}
+		case *ListNode:
+			l := p.length()
+			if l != 2 && l != 3 {
+				return errorResult(errorListOfNItemsExpected(p, 2))
+			}
+			fx, fy, err := evalNumericParams(p.firstChild, p.firstChild.next())
+			if err != nil {
+				return errorResult(err)
+			}
+			x = t.normX(int(fx))
+			y = t.normY(int(fy))
+			if l == 3 {
+				scale, err = evalToNumber(p.firstChild.next().next())
+				if err != nil {
+					return errorResult(err)
+				}
+			}
+		}
+		if scale <= 0 {
+			return errorResult(errorPositiveNumberExpected(parameters[1]))
+		}
+	}
+
+	p, err := ws.files.normPath(name)
+	if err != nil {
+		return errorResult(err)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return errorResult(err)
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	t.drawImage(img, x, y, scale)
+
+	return nil
+}
@@ -3,7 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"fmt"
+	"io"
 	"os"
 	"os/user"
 	"path"
@@ -13,19 +13,33 @@ import (
 var promptPrimary = "? "
 var promptSecondary = "> "
 var greeting = "\nWelcome to Logo\n\n"
+var defaultRecursionLimit = 10000
 
 type Workspace struct {
-	rootFrame    *RootFrame
-	procedures   map[string]Procedure
-	traceEnabled bool
-	broker       *MessageBroker
-	files        *Files
-	screen       *Screen
-	turtle       *Turtle
-	glyphMap     *GlyphMap
-	console      *ConsoleScreen
-	editor       *Editor
-	currentFrame Frame
+	rootFrame     *RootFrame
+	procedures    map[string]Procedure
+	traced        map[string]bool
+	broker        *MessageBroker
+	files         *Files
+	screen        *Screen
+	turtle        *Turtle
+	glyphMap      *GlyphMap
+	console       *ConsoleScreen
+	editor        *Editor
+	currentFrame  Frame
+	lastError     *LogoError
+	interrupted   bool
+	maxDepth      int
+	generation    int
+	listCode      map[*ListNode]*compiledBlock
+	pauseDepth    int
+	errPause      bool
+	errorCatchers int
+	pausedError   error
+	watches       map[string]bool
+	tracedVars    map[string]bool
+	profile       *profiler
+	sandbox       *sandboxState
 }
 
 func CreateWorkspace() *Workspace {
@@ -34,7 +48,7 @@ func CreateWorkspace() *Workspace {
 	if err != nil {
 		panic(err)
 	}
-	ws := &Workspace{nil, make(map[string]Procedure, 100), false, nil, nil, nil, nil, nil, nil, nil, nil}
+	ws := &Workspace{nil, make(map[string]Procedure, 100), make(map[string]bool), nil, nil, nil, nil, nil, nil, nil, nil, nil, false, defaultRecursionLimit, 0, make(map[*ListNode]*compiledBlock), 0, false, 0, nil, make(map[string]bool), make(map[string]bool), nil, nil}
 	ws.rootFrame = &RootFrame{ws, nil, nil, newVarList()}
 	ws.currentFrame = ws.rootFrame
 	ws.broker = CreateMessageBroker()
@@ -46,17 +60,19 @@ func CreateWorkspace() *Workspace {
 	return ws
 }
 
-func (this *Workspace) OpenScreen(w, h int) {
+func (this *Workspace) OpenScreen(w, h int, headless bool) {
 
-	this.screen = initScreen(this, w, h)
+	this.screen = initScreen(this, w, h, headless)
 	this.turtle = initTurtle(this)
-	this.glyphMap = initGlyphMap()
+	this.glyphMap = this.screen.screen.CreateGlyphMap()
 	this.console = initConsole(this, this.screen.screen.W(), this.screen.screen.H())
 	this.editor = initEditor(this, this.screen.screen.W(), this.screen.screen.H())
 
-	this.files.defaultFile = this.console
-	this.files.writer = this.console
-	this.files.reader = this.console
+	if !headless {
+		this.files.defaultFile = this.console
+		this.files.writer = this.console
+		this.files.reader = this.console
+	}
 
 	this.screen.Open()
 }
@@ -78,6 +94,9 @@ func (this *Workspace) listen() {
 		case *KeyMessage:
 			switch rm.Sym {
 			case K_ESCAPE:
+				if listening {
+					this.interrupted = true
+				}
 				if listening && this.currentFrame != nil {
 					procFrame, _ := findInterpretedFrame(this.currentFrame)
 					if procFrame != nil {
@@ -98,23 +117,28 @@ func (this *Workspace) exit() {
 func (this *Workspace) print(text string) error {
 
 	err := this.files.writer.Write(text)
+	if this.files.writer == this.files.defaultFile {
+		this.files.writeDribble(text)
+	}
 	return err
 }
 
-func (this *Workspace) setTrace(trace bool) {
-	this.traceEnabled = trace
+func (this *Workspace) addProcedure(proc *InterpretedProcedure) {
+	this.procedures[proc.name] = proc
+	this.invalidateCode()
 }
 
-func (this *Workspace) trace(depth int, text string) error {
-
-	if !this.traceEnabled {
-		return nil
-	}
-	err := this.files.writer.Write(strings.Repeat(" ", depth) + "(" + fmt.Sprint(depth) + "):" + text + "\n")
-	return err
+func (this *Workspace) removeProcedure(name string) {
+	delete(this.procedures, name)
+	this.invalidateCode()
 }
-func (this *Workspace) addProcedure(proc *InterpretedProcedure) {
-	this.procedures[proc.name] = proc
+
+// invalidateCode discards all compiled procedure bodies, since a call may
+// now resolve to a different procedure or take a different number of
+// inputs.
+func (this *Workspace) invalidateCode() {
+	this.generation++
+	this.listCode = make(map[*ListNode]*compiledBlock)
 }
 
 func (this *Workspace) findProcedure(name string) Procedure {
@@ -126,6 +150,7 @@ func (this *Workspace) findProcedure(name string) Procedure {
 
 func (this *Workspace) registerBuiltIn(longName, shortName string, paramCount int, f evaluator) {
 	p := &BuiltInProcedure{longName, paramCount, false, f}
+	this.invalidateCode()
 
 	this.procedures[longName] = p
 	if shortName != "" {
@@ -135,6 +160,7 @@ func (this *Workspace) registerBuiltIn(longName, shortName string, paramCount in
 
 func (this *Workspace) registerBuiltInWithVarParams(longName, shortName string, paramCount int, f evaluator) {
 	p := &BuiltInProcedure{longName, paramCount, true, f}
+	this.invalidateCode()
 
 	this.procedures[longName] = p
 	if shortName != "" {
@@ -142,14 +168,15 @@ func (this *Workspace) registerBuiltInWithVarParams(longName, shortName string,
 	}
 }
 
-func (this *Workspace) evaluate(source string) error {
+func (this *Workspace) evaluate(source string, line int) error {
 
-	n, err := ParseString(source)
+	n, err := ParseStringAt(source, line)
 	if err != nil {
 		return err
 	}
 
 	this.rootFrame.node = n
+	this.interrupted = false
 	defer func() {
 		this.rootFrame.node = nil
 	}()
@@ -164,12 +191,21 @@ func (this *Workspace) evaluate(source string) error {
 func (this *Workspace) RunInterpreter() {
 	this.print(greeting)
 
-	go this.readFile()
+	go func() {
+		this.readFile(this.files.defaultFile, false)
+		this.broker.PublishId(MT_Quit)
+	}()
 
 	l := this.broker.Subscribe("Interpreter", MT_Quit)
 	l.Wait()
 }
 
+// isDefinitionStart reports whether a line begins a TO or .MACRO
+// definition.
+func isDefinitionStart(lu string) bool {
+	return strings.HasPrefix(lu, keywordTo) || strings.HasPrefix(lu, keywordMacro)
+}
+
 func (this *Workspace) readString(text string) error {
 	b := bytes.NewBufferString(text)
 	s := bufio.NewScanner(b)
@@ -204,12 +240,12 @@ func (this *Workspace) readString(text string) error {
 			if line == "" {
 				continue
 			}
-			if strings.HasPrefix(lu, keywordTo) {
+			if isDefinitionStart(lu) {
 				definingProc = true
 				partial = line
 			} else {
 
-				err := this.evaluate(line)
+				err := this.evaluate(line, 1)
 				if err != nil {
 					return err
 				}
@@ -220,50 +256,74 @@ func (this *Workspace) readString(text string) error {
 	return s.Err()
 }
 
-func (this *Workspace) readFile() error {
+func (this *Workspace) readFile(fr File, batch bool) error {
 	prompt := promptPrimary
 	definingProc := false
 	partial := ""
+	lineNo := 0
+	startLine := 1
+
+	reportError := func(err error) error {
+		if batch {
+			le, ok := err.(*LogoError)
+			if ok && !le.hasPosition() {
+				le.line = startLine
+				le.col = 1
+			}
+			return err
+		}
+		this.print(err.Error())
+		this.print("\n")
+		return nil
+	}
 
 	for {
-		fw := this.files.writer
-		fr := this.files.reader
 		if fr.IsInteractive() {
-			fw.Write(prompt)
+			this.print(prompt)
 		}
 		line, err := fr.ReadLine()
 		if err != nil {
+			if err == io.EOF && definingProc && batch {
+				return reportError(errorKeywordExpected(nil, keywordEnd))
+			}
 			return err
 		}
+		lineNo++
 		lu := strings.ToUpper(line)
 
 		if definingProc {
 			partial += "\n" + line
 			if lu == keywordEnd {
-				fn, err := ParseString(partial)
+				fn, err := ParseStringAt(partial, startLine)
 				if err != nil {
-					fw.Write(err.Error())
-					fw.Write("\n")
+					err = reportError(err)
 				} else {
-					proc, _, err := readInterpretedProcedure(fn)
-					if err != nil {
-						fw.Write(err.Error())
-						fw.Write("\n")
+					proc, _, perr := readInterpretedProcedure(fn)
+					if perr != nil {
+						err = reportError(perr)
 					} else {
 						proc.source = partial
 						this.addProcedure(proc)
-						fw.Write(proc.name + " defined.\n")
+						if !batch {
+							this.print(proc.name + " defined.\n")
+						}
 					}
-					partial = ""
-					prompt = promptPrimary
-					definingProc = false
+				}
+				partial = ""
+				prompt = promptPrimary
+				definingProc = false
+				if err != nil {
+					return err
 				}
 			}
 		} else {
 			if line == "" {
 				continue
 			}
-			if strings.HasPrefix(lu, keywordTo) {
+			if partial == "" {
+				startLine = lineNo
+			}
+			if isDefinitionStart(lu) {
 				definingProc = true
 				prompt = promptSecondary
 				partial = line
@@ -276,15 +336,49 @@ func (this *Workspace) readFile() error {
 					partial = line[0 : len(line)-1]
 					prompt = promptSecondary
 				} else {
-					err = this.evaluate(line)
+					err = this.evaluate(line, startLine)
 					partial = ""
 					prompt = promptPrimary
 					if err != nil {
-						fw.Write(err.Error())
-						fw.Write("\n")
+						err = reportError(err)
+						if err != nil {
+							return err
+						}
 					}
 				}
 			}
 		}
 	}
 }
+
+func (this *Workspace) RunFile(name string) error {
+
+	// The program is opened directly rather than as one of the files
+	// open to Logo, so a sandbox cannot keep it from being read.
+	fi, err := os.Stat(name)
+	if err != nil || fi.IsDir() {
+		return errorNotFile(name)
+	}
+
+	f, err := openNormalFile(0, name, name, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	done := make(chan error, 2)
+	l := this.broker.Subscribe("Runner", MT_Quit)
+	go func() {
+		l.Wait()
+		done <- nil
+	}()
+	go func() {
+		err := this.readFile(f, true)
+		if err == io.EOF {
+			err = nil
+		}
+		done <- err
+	}()
+
+	return <-done
+}